@@ -0,0 +1,239 @@
+// Package client is a Go client of the Grognon JSON API, described by the
+// specification served at /api/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the default client, which times out after 30 seconds
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithToken authenticates the requests with an API token, its scopes
+// restrict the methods that can be called
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// New returns a client of the Grognon instance at baseURL, like http://localhost:3000
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/api/v1",
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is an error response of the API
+type Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("grognon: %s (%d): %s", e.Code, e.Status, e.Message)
+}
+
+// IsNotFound tells whether err is an API error for a missing resource
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.Status == http.StatusNotFound
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			slog.Error("Error closing grognon response", slog.Any("error", err))
+		}
+	}()
+
+	if res.StatusCode >= 400 {
+		var errBody struct {
+			Error Error `json:"error"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&errBody); err != nil || errBody.Error.Code == "" {
+			return &Error{Status: res.StatusCode, Code: "unknown", Message: res.Status}
+		}
+		return &errBody.Error
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func tagQuery(tag string) url.Values {
+	query := url.Values{}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	return query
+}
+
+// ListConnections lists the connections, the ones having tag when it is not empty
+func (c *Client) ListConnections(ctx context.Context, tag string) ([]Connection, error) {
+	var connections []Connection
+	err := c.do(ctx, http.MethodGet, "/connections", tagQuery(tag), nil, &connections)
+	return connections, err
+}
+
+func (c *Client) CreateConnection(ctx context.Context, input ConnectionCreate) (*Connection, error) {
+	var connection Connection
+	if err := c.do(ctx, http.MethodPost, "/connections", nil, input, &connection); err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+func (c *Client) GetConnection(ctx context.Context, connectionId int64) (*Connection, error) {
+	var connection Connection
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/connections/%d", connectionId), nil, nil, &connection); err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+func (c *Client) DeleteConnection(ctx context.Context, connectionId int64) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/connections/%d", connectionId), nil, nil, nil)
+}
+
+// ListConnectionColumns lists the columns of the tables of a connection
+func (c *Client) ListConnectionColumns(ctx context.Context, connectionId int64) ([]Column, error) {
+	var columns []Column
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/connections/%d/columns", connectionId), nil, nil, &columns)
+	return columns, err
+}
+
+// ListCrons lists the crons, the ones of a connection when connectionId is not 0
+// and having tag when it is not empty
+func (c *Client) ListCrons(ctx context.Context, connectionId int64, tag string) ([]Cron, error) {
+	query := tagQuery(tag)
+	if connectionId != 0 {
+		query.Set("connection_id", strconv.FormatInt(connectionId, 10))
+	}
+	var crons []Cron
+	err := c.do(ctx, http.MethodGet, "/crons", query, nil, &crons)
+	return crons, err
+}
+
+func (c *Client) CreateCron(ctx context.Context, input CronCreate) (*Cron, error) {
+	var cron Cron
+	if err := c.do(ctx, http.MethodPost, "/crons", nil, input, &cron); err != nil {
+		return nil, err
+	}
+	return &cron, nil
+}
+
+func (c *Client) GetCron(ctx context.Context, cronId int64) (*Cron, error) {
+	var cron Cron
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/crons/%d", cronId), nil, nil, &cron); err != nil {
+		return nil, err
+	}
+	return &cron, nil
+}
+
+func (c *Client) DeleteCron(ctx context.Context, cronId int64) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/crons/%d", cronId), nil, nil, nil)
+}
+
+func (c *Client) ListCronOutputs(ctx context.Context, cronId int64) ([]CronOutput, error) {
+	var outputs []CronOutput
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/crons/%d/outputs", cronId), nil, nil, &outputs)
+	return outputs, err
+}
+
+// ListCronRuns lists the latest runs of a cron, limit 0 uses the server default
+func (c *Client) ListCronRuns(ctx context.Context, cronId int64, limit int) ([]CronRun, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var runs []CronRun
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/crons/%d/runs", cronId), query, nil, &runs)
+	return runs, err
+}
+
+// QueryCronData reads a page of the samples of a cron
+func (c *Client) QueryCronData(ctx context.Context, cronId int64, q DataQuery) (*DataPage, error) {
+	var page DataPage
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/crons/%d/data", cronId), q.values(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// QueryAllCronData reads the samples of a cron, following the pages until the last one
+func (c *Client) QueryAllCronData(ctx context.Context, cronId int64, q DataQuery) (*DataPage, error) {
+	all, err := c.QueryCronData(ctx, cronId, q)
+	if err != nil {
+		return nil, err
+	}
+	for all.NextCursor != "" {
+		q.Cursor = all.NextCursor
+		page, err := c.QueryCronData(ctx, cronId, q)
+		if err != nil {
+			return nil, err
+		}
+		all.Rows = append(all.Rows, page.Rows...)
+		all.NextCursor = page.NextCursor
+	}
+	return all, nil
+}
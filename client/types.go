@@ -0,0 +1,159 @@
+package client
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ConnectionCreate struct {
+	// Only sqlite is supported
+	DbType        string
+	ConnectionUrl string
+	Name          string
+	Description   string
+	Tags          []string
+}
+
+type Connection struct {
+	ConnectionId    int64
+	DbType          string
+	ConnectionUrl   string
+	CreatedAt       time.Time
+	DeletedAt       *time.Time
+	Connected       bool
+	LastConnectedAt *time.Time
+	LastError       *string
+	Name            string
+	Description     string
+	Tags            []string
+}
+
+type Column struct {
+	ConnectionId int64
+	TableName    string
+	Name         string
+	Type         string
+	Notnull      bool
+	DfltValue    *string
+	PK           int
+}
+
+type CronCreate struct {
+	ConnectionId int64
+	Name         string
+	// SELECT query run on the connection
+	Command string
+	// minute, hour, day, week, month or year
+	Schedule string
+
+	// Running the cron against several connections stores their results
+	// in the same table, labelled with a connection_id column
+	ConnectionIds []int64 `json:",omitempty"`
+	// Fan-out to every connection having this tag
+	ConnectionTag string `json:",omitempty"`
+
+	// Caps on the results of a run, 0 uses the global ones
+	MaxRows  int64 `json:",omitempty"`
+	MaxBytes int64 `json:",omitempty"`
+	// Days of data to keep, 0 uses the global retention
+	RetentionDays int64 `json:",omitempty"`
+	// Resolutions of the aggregated companion tables: hour, day, week or month
+	Rollups []string `json:",omitempty"`
+	// Range partition the data table by week or month, empty for a plain table
+	Partitioning string `json:",omitempty"`
+}
+
+type Cron struct {
+	CronId        int64
+	ConnectionId  int64
+	Name          string
+	Slug          string
+	Command       string
+	Schedule      string
+	FanOut        bool
+	ConnectionTag *string
+	MaxRows       *int64
+	MaxBytes      *int64
+	LastError     *string
+	RetentionDays *int64
+	Partitioning  *string
+	Hypertable    bool
+	CreatedAt     time.Time
+	DeletedAt     *time.Time
+	LastRunAt     *time.Time
+}
+
+// CronOutput is a column of the results of a cron, TEXT outputs are labels
+// and INTEGER or REAL ones are metrics
+type CronOutput struct {
+	CronId int64
+	Name   string
+	Type   string
+}
+
+// CronRun is the execution of a cron on one of its connections
+type CronRun struct {
+	RunId        int64
+	CronId       int64
+	ConnectionId int64
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	RowCount     int64
+	Error        *string
+}
+
+// DataQuery selects samples of a cron, the zero value reads the last day
+type DataQuery struct {
+	From time.Time
+	To   time.Time
+	// Outputs to return, all of them when empty
+	Columns []string
+	// Equality filters on label columns
+	Labels map[string]string
+	// Aggregate the samples by buckets of this length, 0 returns them as they are
+	Bucket time.Duration
+	// avg, min, max, sum, count or last
+	Agg    string
+	Limit  int
+	Cursor string
+}
+
+func (q DataQuery) values() url.Values {
+	query := url.Values{}
+	if !q.From.IsZero() {
+		query.Set("from", q.From.Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		query.Set("to", q.To.Format(time.RFC3339))
+	}
+	if len(q.Columns) > 0 {
+		query.Set("columns", strings.Join(q.Columns, ","))
+	}
+	for label, value := range q.Labels {
+		query.Set("label."+label, value)
+	}
+	if q.Bucket > 0 {
+		query.Set("bucket", q.Bucket.String())
+		if q.Agg != "" {
+			query.Set("agg", q.Agg)
+		}
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		query.Set("cursor", q.Cursor)
+	}
+	return query
+}
+
+type DataPage struct {
+	Columns []string
+	Rows    []map[string]interface{}
+	// Empty on the last page
+	NextCursor string
+	// raw, or the rollup the samples were read from
+	Resolution string
+}
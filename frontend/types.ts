@@ -6,6 +6,9 @@ export type DbType = typeof DB_TYPES[number]
 export type ConnectionCreate = {
   ConnectionUrl: string
   DbType: DbType
+  Name: string
+  Description: string
+  Tags: string[]
 }
 
 export type Connection = {
@@ -37,11 +40,32 @@ export const SCHEDULES = [
 ] as const
 type Schedule = typeof SCHEDULES[number]
 
+export const RESOLUTIONS = [
+  'hour',
+  'day',
+  'week',
+  'month',
+] as const
+export type Resolution = typeof RESOLUTIONS[number]
+
+export const PARTITIONINGS = [
+  'week',
+  'month',
+] as const
+export type Partitioning = typeof PARTITIONINGS[number]
+
 export type CronCreate = {
   ConnectionId: number
   Name: string
   Command: string
   Schedule: Schedule
+  ConnectionIds?: number[]
+  ConnectionTag?: string
+  MaxRows?: number
+  MaxBytes?: number
+  RetentionDays?: number
+  Rollups?: Resolution[]
+  Partitioning?: Partitioning | ''
 }
 
 export type Cron = {
@@ -49,6 +73,14 @@ export type Cron = {
   Name: string
   Command: string
   Schedule: string
+  FanOut: boolean
+  ConnectionTag: string | null
+  MaxRows: number | null
+  MaxBytes: number | null
+  LastError: string | null
+  RetentionDays: number | null
+  Partitioning: Partitioning | null
+  Hypertable: boolean
 
   CronId: number
   CreatedAt: string
@@ -61,3 +93,240 @@ export type CronOutput = {
   Name: string
   Type: string
 }
+
+export type CronPreview = {
+  ConnectionId: number
+  Columns: string[]
+  Rows: Record<string, any>[] | null
+  Outputs: CronOutput[]
+  Errors: Record<string, string>
+  Truncated: boolean
+}
+
+export type CronStats = {
+  RowCount: number
+  OldestSample: string | null
+  NewestSample: string | null
+}
+
+export type CronRollup = {
+  CronId: number
+  Resolution: Resolution
+  LastRolledUpAt: string | null
+}
+
+export const AGGREGATIONS = [
+  'avg',
+  'min',
+  'max',
+  'sum',
+  'count',
+  'last',
+] as const
+
+export const BUCKETS = [
+  { title: 'None', value: '' },
+  { title: '1 minute', value: '1m' },
+  { title: '5 minutes', value: '5m' },
+  { title: '1 hour', value: '1h' },
+  { title: '1 day', value: '1d' },
+  { title: '1 week', value: '7d' },
+]
+
+export type DataPage = {
+  Columns: string[]
+  Rows: Record<string, any>[]
+  NextCursor: string
+  Resolution: string
+}
+
+export type ImportRejection = {
+  Line: number
+  Error: string
+}
+
+export type ImportReport = {
+  DryRun: boolean
+  Imported: number
+  Rejected: number
+  Rejections: ImportRejection[] | null
+  From: string | null
+  To: string | null
+}
+
+export type WebhookCreate = {
+  CronId: number
+  Url: string
+  Secret: string
+  Events: string[]
+}
+
+export type Webhook = {
+  WebhookId: number
+  CronId: number | null
+  Url: string
+  Secret: string
+  Events: string[] | null
+  CreatedAt: string
+  DeletedAt: string | null
+}
+
+export type WebhookDelivery = {
+  DeliveryId: number
+  WebhookId: number
+  Event: string
+  Payload: string
+  Status: 'pending' | 'delivered' | 'failed'
+  Attempts: number
+  NextAttemptAt: string
+  ResponseStatus: number | null
+  LastError: string | null
+  CreatedAt: string
+  DeliveredAt: string | null
+}
+
+export const CONDITIONS = [
+  { title: 'Above', value: 'above' },
+  { title: 'Below', value: 'below' },
+  { title: 'Change rate (per minute)', value: 'change_rate' },
+  { title: 'Absent for (seconds)', value: 'absent_for' },
+  { title: 'Anomaly score above', value: 'anomaly' },
+] as const
+
+export type Condition = typeof CONDITIONS[number]['value']
+
+export type AlertRuleCreate = {
+  CronId: number
+  Name: string
+  ColumnName: string
+  LabelFilter: Record<string, string>
+  Condition: Condition
+  Threshold: number
+  ForSeconds: number
+}
+
+export type AlertRule = AlertRuleCreate & {
+  RuleId: number
+  CreatedAt: string
+  DeletedAt: string | null
+}
+
+export type AlertState = 'pending' | 'firing' | 'resolved'
+
+export type Alert = {
+  AlertId: number
+  RuleId: number
+  Series: Record<string, string>
+  State: AlertState
+  Value: number | null
+  StartedAt: string
+  FiredAt: string | null
+  ResolvedAt: string | null
+  UpdatedAt: string
+}
+
+export const ALERT_COLORS: Record<AlertState, string> = {
+  pending: 'warning',
+  firing: 'error',
+  resolved: 'success',
+}
+
+export type ChannelKind = 'email' | 'slack'
+
+export type NotificationChannelCreate = {
+  Name: string
+  Kind: ChannelKind
+  Target: string
+  Events: string[]
+  CronId: number
+  ConnectionTag: string
+  Template: string
+  ThrottleSeconds: number
+}
+
+export type NotificationChannel = {
+  ChannelId: number
+  Name: string
+  Kind: ChannelKind
+  Target: string
+  Events: string[] | null
+  CronId: number | null
+  ConnectionTag: string | null
+  Template: string
+  ThrottleSeconds: number
+  CreatedAt: string
+  DeletedAt: string | null
+}
+
+export type Notification = {
+  NotificationId: number
+  ChannelId: number
+  Event: string
+  ThrottleKey: string
+  Status: 'sent' | 'throttled' | 'failed'
+  Message: string
+  Error: string | null
+  CreatedAt: string
+}
+
+export const DETECTOR_METHODS = [
+  { title: 'Rolling z-score', value: 'zscore' },
+  { title: 'Seasonal baseline', value: 'seasonal' },
+] as const
+
+export const SEASONS = ['day', 'week'] as const
+
+export type AnomalyDetectorCreate = {
+  ColumnName: string
+  Method: typeof DETECTOR_METHODS[number]['value']
+  Threshold: number
+  WindowSize: number
+  Season: string
+}
+
+export type AnomalyDetector = AnomalyDetectorCreate & {
+  DetectorId: number
+  CronId: number
+  Season: string | null
+  CreatedAt: string
+  DeletedAt: string | null
+}
+
+export type AnnotationInput = {
+  CronId: number
+  ConnectionId: number
+  Time: string
+  TimeEnd: string | null
+  Text: string
+  Tags: string[]
+}
+
+export type Annotation = {
+  AnnotationId: number
+  CronId: number | null
+  ConnectionId: number | null
+  Time: string
+  TimeEnd: string | null
+  Text: string
+  Tags: string[] | null
+  CreatedAt: string
+}
+
+export type APITokenCreate = {
+  Name: string
+  Kind: string
+  Scopes: string[]
+  ExpiresInDays: number
+}
+
+export type APIToken = {
+  TokenId: number
+  Name: string
+  Kind: string
+  Prefix: string
+  Scopes: string[]
+  ExpiresAt: string | null
+  LastUsedAt: string | null
+  CreatedAt: string
+  RevokedAt: string | null
+}
@@ -1,3 +1,12 @@
+import type { Connection } from '@/types'
+
+export function connectionName(connection?: Connection): string {
+  if (!connection) {
+    return ''
+  }
+  return connection.Name || connection.ConnectionUrl
+}
+
 export function displayTime(time: Date | string | number): string {
   if (!time) {
     return ''
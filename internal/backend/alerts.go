@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+	inertia "github.com/romsar/gonertia"
+)
+
+// Alerts shown on a rule page
+const alertHistorySize = 100
+
+// cronColumns is what the rule form offers for a cron
+type cronColumns struct {
+	Metrics []string
+	Labels  []string
+}
+
+func GetAlerts(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+
+		rules, err := database.GetAlertRules(db, nil)
+		if err != nil {
+			slog.Error("Failed to get alert rules", slog.Any("error", err))
+			errs.Add("rules", err)
+		}
+
+		alerts, err := database.GetActiveAlerts(db)
+		if err != nil {
+			slog.Error("Failed to get active alerts", slog.Any("error", err))
+			errs.Add("alerts", err)
+		}
+
+		crons, err := database.GetCrons(db, nil, nil)
+		if err != nil {
+			slog.Error("Failed to get crons", slog.Any("error", err))
+			errs.Add("crons", err)
+		}
+
+		columns := map[int64]cronColumns{}
+		for _, cron := range crons {
+			outputs, err := database.GetCronOutputs(db, cron.CronId)
+			if err != nil {
+				slog.Error("Failed to get cron outputs", slog.Any("error", err))
+				errs.Add("outputs", err)
+				break
+			}
+			c := cronColumns{Metrics: []string{}, Labels: cron.Labels(outputs)}
+			for _, output := range outputs {
+				if output.IsNumeric() {
+					c.Metrics = append(c.Metrics, output.Name)
+				}
+			}
+			columns[cron.CronId] = c
+		}
+
+		props := inertia.Props{
+			"rules":      rules,
+			"alerts":     alerts,
+			"crons":      crons,
+			"columns":    columns,
+			"conditions": database.Conditions,
+		}
+
+		Render(w, errs.Request(r), i, "Home/Alerts", props)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func PostNewAlertRules(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+
+		var body database.AlertRuleCreate
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			slog.Error("Failed to decode request body", slog.Any("error", err))
+			errs.Add("body", err)
+		}
+
+		rule, err := database.AddAlertRule(db, body)
+		if err != nil {
+			slog.Error("Failed to create alert rule", slog.Any("error", err))
+			if unwrapped := errors.Unwrap(err); unwrapped != nil {
+				err = unwrapped
+			}
+			errs.Add("creation", err)
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+			i.Back(w, r)
+		} else {
+			url := fmt.Sprintf("/alerts/rules/%d", rule.RuleId)
+			i.Redirect(w, r, url)
+		}
+		SaveSession(w, r)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func GetAlertRule(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		ruleId, err := strconv.ParseInt(vars["rule_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse alert rule id", slog.Any("error", err))
+			errs.Add("input", err)
+
+			Render(w, errs.Request(r), i, "Home/AlertRule", nil)
+			return
+		}
+		props := inertia.Props{
+			"ruleId": ruleId,
+		}
+
+		rule, err := database.GetAlertRule(db, ruleId)
+		if err != nil {
+			slog.Error("Failed to get alert rule", slog.Any("error", err))
+			errs.Add("rule", err)
+
+			Render(w, errs.Request(r), i, "Home/AlertRule", props)
+			return
+		}
+		props["rule"] = rule
+
+		cron, err := database.GetCron(db, rule.CronId)
+		if err != nil {
+			slog.Error("Failed to get cron", slog.Any("error", err))
+			errs.Add("cron", err)
+		}
+		props["cron"] = cron
+
+		alerts, err := database.GetAlerts(db, ruleId, alertHistorySize)
+		if err != nil {
+			slog.Error("Failed to get alerts", slog.Any("error", err))
+			errs.Add("alerts", err)
+		}
+		props["alerts"] = alerts
+
+		Render(w, errs.Request(r), i, "Home/AlertRule", props)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func DeleteAlertRule(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		ruleId, err := strconv.ParseInt(vars["rule_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse alert rule id", slog.Any("error", err))
+			errs.Add("input", err)
+
+			Render(w, errs.Request(r), i, "Home/AlertRule", nil)
+			return
+		}
+
+		err = database.DeleteAlertRule(db, ruleId)
+		if err != nil {
+			slog.Error("Failed to delete alert rule", slog.Any("error", err))
+			errs.Add("deletion", err)
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+			i.Back(w, r)
+		} else {
+			i.Redirect(w, r, "/alerts")
+		}
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+)
+
+// GetAnnotations lists the annotations overlapping the from and to query parameters,
+// optionally the ones applying to a cron_id and having every tag
+func GetAnnotations(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseTimeRange(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		q := database.AnnotationQuery{From: from, To: to, Tags: r.URL.Query()["tag"]}
+
+		if v := r.URL.Query().Get("cron_id"); v != "" {
+			cronId, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid cron_id: %w", err))
+				return
+			}
+			q.CronId = &cronId
+		}
+
+		annotations, err := database.GetAnnotations(db, q)
+		if err != nil {
+			slog.Error("Failed to get annotations", slog.Any("error", err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if annotations == nil {
+			annotations = []database.Annotation{}
+		}
+		writeJSON(w, http.StatusOK, annotations)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func PostAnnotation(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var body database.AnnotationInput
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		annotation, err := database.AddAnnotation(db, body)
+		if err != nil {
+			slog.Error("Failed to create annotation", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, annotation)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// writeAnnotationError reports a missing annotation, any other lookup failure is logged
+func writeAnnotationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrAnnotationNotFound) {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	slog.Error("Failed to get annotation", slog.Any("error", err))
+	writeJSONError(w, http.StatusInternalServerError, err)
+}
+
+func PutAnnotation(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		annotationId, err := strconv.ParseInt(mux.Vars(r)["annotation_id"], 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var body database.AnnotationInput
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if _, err := database.GetAnnotation(db, annotationId); err != nil {
+			writeAnnotationError(w, err)
+			return
+		}
+		annotation, err := database.UpdateAnnotation(db, annotationId, body)
+		if err != nil {
+			slog.Error("Failed to update annotation", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, annotation)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func DeleteAnnotation(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		annotationId, err := strconv.ParseInt(mux.Vars(r)["annotation_id"], 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if _, err := database.GetAnnotation(db, annotationId); err != nil {
+			writeAnnotationError(w, err)
+			return
+		}
+		if err := database.DeleteAnnotation(db, annotationId); err != nil {
+			slog.Error("Failed to delete annotation", slog.Any("error", err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	return http.HandlerFunc(fn)
+}
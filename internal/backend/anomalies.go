@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+	inertia "github.com/romsar/gonertia"
+)
+
+// Anomalies listed on a cron page
+const anomalyListSize = 20
+
+func PostCronDetector(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		cronId, err := strconv.ParseInt(vars["cron_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse cron id", slog.Any("error", err))
+			errs.Add("input", err)
+		}
+
+		var body database.AnomalyDetectorCreate
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			slog.Error("Failed to decode request body", slog.Any("error", err))
+			errs.Add("body", err)
+		}
+		body.CronId = cronId
+
+		if !errs.HasErrors() {
+			if _, err := database.AddAnomalyDetector(db, body); err != nil {
+				slog.Error("Failed to create anomaly detector", slog.Any("error", err))
+				if unwrapped := errors.Unwrap(err); unwrapped != nil {
+					err = unwrapped
+				}
+				errs.Add("creation", err)
+			}
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+		}
+		i.Back(w, r)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func DeleteCronDetector(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		detectorId, err := strconv.ParseInt(vars["detector_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse detector id", slog.Any("error", err))
+			errs.Add("input", err)
+		} else if err := database.DeleteAnomalyDetector(db, detectorId); err != nil {
+			slog.Error("Failed to delete anomaly detector", slog.Any("error", err))
+			errs.Add("deletion", err)
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+		}
+		i.Back(w, r)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
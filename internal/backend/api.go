@@ -0,0 +1,452 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+)
+
+// Versioned JSON API, for scripts and other services. Requests are authenticated by
+// API tokens sent as bearer tokens, errors are reported as
+// {"error": {"status", "code", "message"}} objects with a matching HTTP status.
+
+const (
+	apiBadRequest       = "bad_request"
+	apiNotFound         = "not_found"
+	apiInvalid          = "invalid"
+	apiInternal         = "internal"
+	apiUnauthorized     = "unauthorized"
+	apiForbidden        = "forbidden"
+	apiMethodNotAllowed = "method_not_allowed"
+)
+
+// Default and maximum numbers of runs listed
+const (
+	apiRunsLimit    = 50
+	apiRunsMaxLimit = 1000
+)
+
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code string, err error) {
+	writeJSON(w, status, map[string]APIError{
+		"error": {Status: status, Code: code, Message: err.Error()},
+	})
+}
+
+// writeAPIInputError reports the inputs rejected by the database layer as unprocessable,
+// any other failure is an internal error
+func writeAPIInputError(w http.ResponseWriter, err error) {
+	var invalid *database.InvalidInputError
+	if errors.As(err, &invalid) {
+		writeAPIError(w, http.StatusUnprocessableEntity, apiInvalid, err)
+		return
+	}
+	writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+}
+
+// setupAPI registers the API routes, it returns the API router which openapi.json describes
+func setupAPI(router *mux.Router, db *database.Database, cons database.Connections) *mux.Router {
+	router.Methods("GET").Path("/api/openapi.json").
+		Handler(GetOpenAPI())
+
+	api := router.PathPrefix("/api/v1").Subrouter()
+
+	api.Methods("GET").Path("/connections").
+		Handler(apiAuth(db, database.ScopeReadData, APIGetConnections(db)))
+	api.Methods("POST").Path("/connections").
+		Handler(apiAuth(db, database.ScopeManageConnections, APIPostConnection(db, cons)))
+	api.Methods("GET").Path("/connections/{connection_id}").
+		Handler(apiAuth(db, database.ScopeReadData, APIGetConnection(db)))
+	api.Methods("DELETE").Path("/connections/{connection_id}").
+		Handler(apiAuth(db, database.ScopeManageConnections, APIDeleteConnection(db, cons)))
+	api.Methods("GET").Path("/connections/{connection_id}/columns").
+		Handler(apiAuth(db, database.ScopeReadData, APIGetConnectionColumns(db)))
+	api.Methods("GET").Path("/crons").
+		Handler(apiAuth(db, database.ScopeReadData, APIGetCrons(db)))
+	api.Methods("POST").Path("/crons").
+		Handler(apiAuth(db, database.ScopeManageCrons, APIPostCron(db, cons)))
+	api.Methods("GET").Path("/crons/{cron_id}").
+		Handler(apiAuth(db, database.ScopeReadData, APIGetCron(db)))
+	api.Methods("DELETE").Path("/crons/{cron_id}").
+		Handler(apiAuth(db, database.ScopeManageCrons, APIDeleteCron(db)))
+	api.Methods("GET").Path("/crons/{cron_id}/outputs").
+		Handler(apiAuth(db, database.ScopeReadData, APIGetCronOutputs(db)))
+	api.Methods("GET").Path("/crons/{cron_id}/runs").
+		Handler(apiAuth(db, database.ScopeReadData, APIGetCronRuns(db)))
+	api.Methods("GET").Path("/crons/{cron_id}/data").
+		Handler(apiAuth(db, database.ScopeReadData, APIGetCronData(db)))
+
+	api.NotFoundHandler = apiNoRoute(api)
+	api.MethodNotAllowedHandler = apiNoRoute(api)
+
+	return api
+}
+
+// apiNoRoute tells apart unknown paths from known ones requested with another method,
+// mux can report either for a method mismatch depending on the order of the routes
+func apiNoRoute(api *mux.Router) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+			req := r.Clone(r.Context())
+			req.Method = method
+			var match mux.RouteMatch
+			if api.Match(req, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+
+		if len(allowed) == 0 {
+			writeAPIError(w, http.StatusNotFound, apiNotFound, fmt.Errorf("no route for %s", r.URL.Path))
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeAPIError(w, http.StatusMethodNotAllowed, apiMethodNotAllowed, fmt.Errorf("method %s not allowed on %s", r.Method, r.URL.Path))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// apiAuth lets through the requests bearing a valid API token having the scope
+func apiAuth(db *database.Database, scope string, next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || secret == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, apiUnauthorized, fmt.Errorf("missing bearer token"))
+			return
+		}
+
+		token, err := database.AuthenticateAPIToken(db, strings.TrimSpace(secret))
+		if errors.Is(err, database.ErrInvalidAPIToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeAPIError(w, http.StatusUnauthorized, apiUnauthorized, err)
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to authenticate api token", slog.Any("error", err))
+			writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+			return
+		}
+		if !token.HasScope(scope) {
+			writeAPIError(w, http.StatusForbidden, apiForbidden, fmt.Errorf("token lacks the %s scope", scope))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// apiPathId parses an id variable of the route, reporting a bad request on failure
+func apiPathId(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiBadRequest, fmt.Errorf("invalid %s: %w", name, err))
+		return 0, false
+	}
+	return id, true
+}
+
+func apiDecode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiBadRequest, fmt.Errorf("invalid body: %w", err))
+		return false
+	}
+	return true
+}
+
+// apiCron loads the cron of the route, reporting it missing on failure
+func apiCron(w http.ResponseWriter, r *http.Request, db *database.Database) (*database.Cron, bool) {
+	cronId, ok := apiPathId(w, r, "cron_id")
+	if !ok {
+		return nil, false
+	}
+	cron, err := database.GetCron(db, cronId)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, apiNotFound, err)
+		return nil, false
+	}
+	return cron, true
+}
+
+func APIGetConnections(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		connections, err := database.GetConnections(db, queryTag(r))
+		if err != nil {
+			slog.Error("Failed to get connections", slog.Any("error", err))
+			writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+			return
+		}
+		if connections == nil {
+			connections = []database.Connection{}
+		}
+		writeJSON(w, http.StatusOK, connections)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIPostConnection(db *database.Database, cons database.Connections) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var body database.ConnectionCreate
+		if !apiDecode(w, r, &body) {
+			return
+		}
+
+		con, err := database.AddConnection(db, cons, body)
+		if err != nil {
+			slog.Error("Failed to create connection", slog.Any("error", err))
+			writeAPIInputError(w, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/connections/%d", con.ConnectionId))
+		writeJSON(w, http.StatusCreated, con)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIGetConnection(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		connectionId, ok := apiPathId(w, r, "connection_id")
+		if !ok {
+			return
+		}
+
+		con, err := database.GetConnection(db, connectionId)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, apiNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, con)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIDeleteConnection(db *database.Database, cons database.Connections) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		connectionId, ok := apiPathId(w, r, "connection_id")
+		if !ok {
+			return
+		}
+
+		if _, err := database.GetConnection(db, connectionId); err != nil {
+			writeAPIError(w, http.StatusNotFound, apiNotFound, err)
+			return
+		}
+		if err := database.DeleteConnection(db, cons, connectionId); err != nil {
+			slog.Error("Failed to delete connection", slog.Any("error", err))
+			writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIGetConnectionColumns(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		connectionId, ok := apiPathId(w, r, "connection_id")
+		if !ok {
+			return
+		}
+
+		if _, err := database.GetConnection(db, connectionId); err != nil {
+			writeAPIError(w, http.StatusNotFound, apiNotFound, err)
+			return
+		}
+		columns, err := database.GetColumns(db, connectionId)
+		if err != nil {
+			slog.Error("Failed to get columns", slog.Any("error", err))
+			writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+			return
+		}
+		if columns == nil {
+			columns = []database.Column{}
+		}
+		writeJSON(w, http.StatusOK, columns)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIGetCrons(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var connectionId *int64
+		if v := r.URL.Query().Get("connection_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, apiBadRequest, fmt.Errorf("invalid connection_id: %w", err))
+				return
+			}
+			connectionId = &id
+		}
+
+		crons, err := database.GetCrons(db, connectionId, queryTag(r))
+		if err != nil {
+			slog.Error("Failed to get crons", slog.Any("error", err))
+			writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+			return
+		}
+		if crons == nil {
+			crons = []database.Cron{}
+		}
+		writeJSON(w, http.StatusOK, crons)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIPostCron(db *database.Database, cons database.Connections) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var body database.CronCreate
+		if !apiDecode(w, r, &body) {
+			return
+		}
+
+		cron, err := database.AddCron(db, cons, body)
+		if err != nil {
+			slog.Error("Failed to create cron", slog.Any("error", err))
+			writeAPIInputError(w, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/crons/%d", cron.CronId))
+		writeJSON(w, http.StatusCreated, cron)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIGetCron(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		cron, ok := apiCron(w, r, db)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, cron)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIDeleteCron(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		cron, ok := apiCron(w, r, db)
+		if !ok {
+			return
+		}
+
+		if err := database.DeleteCron(db, cron.CronId); err != nil {
+			slog.Error("Failed to delete cron", slog.Any("error", err))
+			writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIGetCronOutputs(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		cron, ok := apiCron(w, r, db)
+		if !ok {
+			return
+		}
+
+		outputs, err := database.GetCronOutputs(db, cron.CronId)
+		if err != nil {
+			slog.Error("Failed to get cron outputs", slog.Any("error", err))
+			writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+			return
+		}
+		if outputs == nil {
+			outputs = []database.CronOutput{}
+		}
+		writeJSON(w, http.StatusOK, outputs)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func APIGetCronRuns(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		cron, ok := apiCron(w, r, db)
+		if !ok {
+			return
+		}
+
+		limit := apiRunsLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > apiRunsMaxLimit {
+				writeAPIError(w, http.StatusBadRequest, apiBadRequest, fmt.Errorf("limit must be between 1 and %d", apiRunsMaxLimit))
+				return
+			}
+			limit = n
+		}
+
+		runs, err := database.GetCronRuns(db, cron.CronId, limit)
+		if err != nil {
+			slog.Error("Failed to get cron runs", slog.Any("error", err))
+			writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+			return
+		}
+		if runs == nil {
+			runs = []database.CronRun{}
+		}
+		writeJSON(w, http.StatusOK, runs)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// APIGetCronData takes the query parameters of the data view: from, to, columns,
+// label.<name>, bucket, agg, limit and cursor
+func APIGetCronData(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		cron, ok := apiCron(w, r, db)
+		if !ok {
+			return
+		}
+
+		q, err := parseDataQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, apiBadRequest, err)
+			return
+		}
+
+		outputs, err := database.GetCronOutputs(db, cron.CronId)
+		if err != nil {
+			slog.Error("Failed to get cron outputs", slog.Any("error", err))
+			writeAPIError(w, http.StatusInternalServerError, apiInternal, err)
+			return
+		}
+
+		page, err := database.QueryCronData(db, *cron, outputs, q)
+		if err != nil {
+			slog.Error("Failed to query cron data", slog.Any("error", err))
+			writeAPIError(w, http.StatusBadRequest, apiBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+
+	return http.HandlerFunc(fn)
+}
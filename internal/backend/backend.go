@@ -1,17 +1,20 @@
 package backend
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"d34d.one/grognon/internal/database"
+	"d34d.one/grognon/internal/notify"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	inertia "github.com/romsar/gonertia"
 )
 
-func Setup(db *database.Database, cons database.Connections, ssrHost string) error {
+func Setup(db *database.Database, cons database.Connections, notifier *notify.Notifier, ssrHost string) error {
 	sessionKey := os.Getenv("SESSION_KEY")
 	if sessionKey == "" {
 		slog.Warn("SESSION_KEY not set")
@@ -26,6 +29,35 @@ func Setup(db *database.Database, cons database.Connections, ssrHost string) err
 	router := mux.NewRouter()
 
 	router.PathPrefix("/build").Handler(http.StripPrefix("/build/", http.FileServer(http.Dir("./public/build"))))
+	// Scrapers and datasources authenticate with API tokens, like the API. The
+	// other routes serve the web UI and are not authenticated.
+	router.Methods("GET").Path("/metrics").
+		Handler(apiAuth(db, database.ScopeReadData, GetMetrics(db)))
+
+	setupAPI(router, db, cons)
+
+	grafana := router.PathPrefix("/grafana").Subrouter()
+	grafana.Methods("GET").Path("/").
+		Handler(apiAuth(db, database.ScopeReadData, GetGrafana()))
+	grafana.Methods("POST").Path("/search").
+		Handler(apiAuth(db, database.ScopeReadData, PostGrafanaSearch(db)))
+	grafana.Methods("POST").Path("/query").
+		Handler(apiAuth(db, database.ScopeReadData, PostGrafanaQuery(db)))
+	grafana.Methods("POST").Path("/annotations").
+		Handler(apiAuth(db, database.ScopeReadData, PostGrafanaAnnotations(db)))
+	grafana.Methods("POST").Path("/tag-keys").
+		Handler(apiAuth(db, database.ScopeReadData, PostGrafanaTagKeys(db)))
+	grafana.Methods("POST").Path("/tag-values").
+		Handler(apiAuth(db, database.ScopeReadData, PostGrafanaTagValues(db)))
+
+	router.Methods("GET").Path("/annotations").
+		Handler(GetAnnotations(db))
+	router.Methods("POST").Path("/annotations").
+		Handler(PostAnnotation(db))
+	router.Methods("PUT").Path("/annotations/{annotation_id}").
+		Handler(PutAnnotation(db))
+	router.Methods("DELETE").Path("/annotations/{annotation_id}").
+		Handler(DeleteAnnotation(db))
 
 	router.Methods("GET").Path("/connections/create").
 		Handler(GetNewConnections(i))
@@ -43,6 +75,18 @@ func Setup(db *database.Database, cons database.Connections, ssrHost string) err
 		Handler(PostNewConnections(i, db, cons))
 	router.Methods("GET").Path("/crons/create").
 		Handler(GetNewCrons(i, db))
+	router.Methods("POST").Path("/crons/preview").
+		Handler(PostCronPreview(db, cons))
+	router.Methods("GET").Path("/crons/{cron_id}/query").
+		Handler(GetCronQuery(db))
+	router.Methods("GET").Path("/crons/{cron_id}/data.{format:csv|jsonl|parquet}").
+		Handler(GetCronExport(db))
+	router.Methods("POST").Path("/crons/{cron_id}/import").
+		Handler(PostCronImport(db))
+	router.Methods("POST").Path("/crons/{cron_id}/detectors").
+		Handler(PostCronDetector(i, db))
+	router.Methods("DELETE").Path("/crons/{cron_id}/detectors/{detector_id}").
+		Handler(DeleteCronDetector(i, db))
 	router.Methods("GET").Path("/crons/{cron_id}/data").
 		Handler(GetCronData(i, db))
 	router.Methods("GET").Path("/crons/{cron_id}").
@@ -53,6 +97,38 @@ func Setup(db *database.Database, cons database.Connections, ssrHost string) err
 		Handler(GetCrons(i, db))
 	router.Methods("POST").Path("/crons").
 		Handler(PostNewCrons(i, db, cons))
+	router.Methods("GET").Path("/alerts/rules/{rule_id}").
+		Handler(GetAlertRule(i, db))
+	router.Methods("DELETE").Path("/alerts/rules/{rule_id}").
+		Handler(DeleteAlertRule(i, db))
+	router.Methods("POST").Path("/alerts/rules").
+		Handler(PostNewAlertRules(i, db))
+	router.Methods("GET").Path("/alerts").
+		Handler(GetAlerts(i, db))
+	router.Methods("GET").Path("/channels/{channel_id}").
+		Handler(GetChannel(i, db))
+	router.Methods("DELETE").Path("/channels/{channel_id}").
+		Handler(DeleteChannel(i, db))
+	router.Methods("POST").Path("/channels/{channel_id}/test").
+		Handler(PostChannelTest(i, db, notifier))
+	router.Methods("GET").Path("/channels").
+		Handler(GetChannels(i, db, notifier))
+	router.Methods("POST").Path("/channels").
+		Handler(PostNewChannels(i, db))
+	router.Methods("GET").Path("/webhooks/{webhook_id}").
+		Handler(GetWebhook(i, db))
+	router.Methods("DELETE").Path("/webhooks/{webhook_id}").
+		Handler(DeleteWebhook(i, db))
+	router.Methods("GET").Path("/webhooks").
+		Handler(GetWebhooks(i, db))
+	router.Methods("POST").Path("/webhooks").
+		Handler(PostNewWebhooks(i, db))
+	router.Methods("DELETE").Path("/tokens/{token_id}").
+		Handler(DeleteToken(i, db))
+	router.Methods("GET").Path("/tokens").
+		Handler(GetTokens(i, db))
+	router.Methods("POST").Path("/tokens").
+		Handler(PostNewTokens(i, db))
 	router.Methods("GET").Path("/").
 		Handler(http.RedirectHandler("/connections", http.StatusTemporaryRedirect))
 	router.PathPrefix("/").
@@ -81,6 +157,33 @@ func NotFound(i *inertia.Inertia) http.Handler {
 	return i.Middleware(http.HandlerFunc(fn))
 }
 
+// parseTimeRange reads the from and to RFC 3339 query parameters,
+// defaulting to the last day
+func parseTimeRange(r *http.Request) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+
+	if v := query.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to: %w", err)
+		}
+		to = t
+	}
+	if v := query.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from: %w", err)
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
 type Errors struct {
 	session *sessions.Session
 	errs    inertia.ValidationErrors
@@ -0,0 +1,199 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"d34d.one/grognon/internal/database"
+	"d34d.one/grognon/internal/notify"
+	"github.com/gorilla/mux"
+	inertia "github.com/romsar/gonertia"
+)
+
+// Entries of the notification log shown on a channel page
+const notificationLogSize = 100
+
+func GetChannels(i *inertia.Inertia, db *database.Database, notifier *notify.Notifier) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+
+		channels, err := database.GetNotificationChannels(db)
+		if err != nil {
+			slog.Error("Failed to get notification channels", slog.Any("error", err))
+			errs.Add("channels", err)
+		}
+
+		crons, err := database.GetCrons(db, nil, nil)
+		if err != nil {
+			slog.Error("Failed to get crons", slog.Any("error", err))
+			errs.Add("crons", err)
+		}
+
+		tags, err := database.GetTags(db)
+		if err != nil {
+			slog.Error("Failed to get tags", slog.Any("error", err))
+			errs.Add("tags", err)
+		}
+
+		props := inertia.Props{
+			"channels":        channels,
+			"crons":           crons,
+			"tags":            tags,
+			"kinds":           notifier.Kinds(),
+			"events":          database.EventTypes,
+			"defaultEvents":   database.DefaultChannelEvents,
+			"defaultTemplate": notify.DefaultTemplate,
+		}
+
+		Render(w, errs.Request(r), i, "Home/Channels", props)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func PostNewChannels(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+
+		var body database.NotificationChannelCreate
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			slog.Error("Failed to decode request body", slog.Any("error", err))
+			errs.Add("body", err)
+		}
+
+		channel, err := database.AddNotificationChannel(db, body)
+		if err != nil {
+			slog.Error("Failed to create notification channel", slog.Any("error", err))
+			if unwrapped := errors.Unwrap(err); unwrapped != nil {
+				err = unwrapped
+			}
+			errs.Add("creation", err)
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+			i.Back(w, r)
+		} else {
+			url := fmt.Sprintf("/channels/%d", channel.ChannelId)
+			i.Redirect(w, r, url)
+		}
+		SaveSession(w, r)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func GetChannel(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		channelId, err := strconv.ParseInt(vars["channel_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse channel id", slog.Any("error", err))
+			errs.Add("input", err)
+
+			Render(w, errs.Request(r), i, "Home/Channel", nil)
+			return
+		}
+		props := inertia.Props{
+			"channelId": channelId,
+		}
+
+		channel, err := database.GetNotificationChannel(db, channelId)
+		if err != nil {
+			slog.Error("Failed to get notification channel", slog.Any("error", err))
+			errs.Add("channel", err)
+
+			Render(w, errs.Request(r), i, "Home/Channel", props)
+			return
+		}
+		props["channel"] = channel
+
+		if channel.CronId != nil {
+			cron, err := database.GetCron(db, *channel.CronId)
+			if err != nil {
+				slog.Error("Failed to get cron", slog.Any("error", err))
+				errs.Add("cron", err)
+			}
+			props["cron"] = cron
+		}
+
+		notifications, err := database.GetNotifications(db, channelId, notificationLogSize)
+		if err != nil {
+			slog.Error("Failed to get notifications", slog.Any("error", err))
+			errs.Add("notifications", err)
+		}
+		props["notifications"] = notifications
+		props["defaultEvents"] = database.DefaultChannelEvents
+
+		Render(w, errs.Request(r), i, "Home/Channel", props)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func PostChannelTest(i *inertia.Inertia, db *database.Database, notifier *notify.Notifier) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		channelId, err := strconv.ParseInt(vars["channel_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse channel id", slog.Any("error", err))
+			errs.Add("input", err)
+		} else {
+			channel, err := database.GetNotificationChannel(db, channelId)
+			if err == nil {
+				err = notifier.Test(r.Context(), *channel)
+			}
+			if err != nil {
+				slog.Error("Failed to test notification channel", slog.Any("error", err))
+				errs.Add("test", err)
+			}
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+		}
+		i.Back(w, r)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func DeleteChannel(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		channelId, err := strconv.ParseInt(vars["channel_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse channel id", slog.Any("error", err))
+			errs.Add("input", err)
+
+			Render(w, errs.Request(r), i, "Home/Channel", nil)
+			return
+		}
+
+		err = database.DeleteNotificationChannel(db, channelId)
+		if err != nil {
+			slog.Error("Failed to delete notification channel", slog.Any("error", err))
+			errs.Add("deletion", err)
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+			i.Back(w, r)
+		} else {
+			i.Redirect(w, r, "/channels")
+		}
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
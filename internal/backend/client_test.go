@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"d34d.one/grognon/client"
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+)
+
+func TestClientMatchesOpenAPI(t *testing.T) {
+	types := map[string]reflect.Type{
+		"ErrorResponse": reflect.TypeOf(struct {
+			Error client.Error `json:"error"`
+		}{}),
+		"ConnectionCreate": reflect.TypeOf(client.ConnectionCreate{}),
+		"Connection":       reflect.TypeOf(client.Connection{}),
+		"Column":           reflect.TypeOf(client.Column{}),
+		"CronCreate":       reflect.TypeOf(client.CronCreate{}),
+		"Cron":             reflect.TypeOf(client.Cron{}),
+		"CronOutput":       reflect.TypeOf(client.CronOutput{}),
+		"CronRun":          reflect.TypeOf(client.CronRun{}),
+		"DataPage":         reflect.TypeOf(client.DataPage{}),
+	}
+	if err := checkOpenAPISchemas(types); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestClientRoundTrip creates a cron through the client and reads its samples back,
+// it needs a Postgres database given by GROGNON_TEST_DB
+func TestClientRoundTrip(t *testing.T) {
+	dbUrl := os.Getenv("GROGNON_TEST_DB")
+	if dbUrl == "" {
+		t.Skip("GROGNON_TEST_DB not set")
+	}
+	ctx := context.Background()
+
+	db, err := database.Setup(ctx, dbUrl, database.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cons := database.Connections{}
+	_, secret, err := database.AddAPIToken(db, database.APITokenCreate{
+		Name:   "round trip",
+		Kind:   database.TokenService,
+		Scopes: database.TokenScopes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Connections are opened read only, the file must exist
+	path := filepath.Join(t.TempDir(), "source.db")
+	source, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Exec("CREATE TABLE hosts (name TEXT, load INTEGER); INSERT INTO hosts VALUES ('a', 1), ('b', 2);"); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	setupAPI(router, db, cons)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	c := client.New(server.URL, client.WithToken(secret))
+
+	con, err := c.CreateConnection(ctx, client.ConnectionCreate{DbType: "sqlite", ConnectionUrl: path, Name: "round trip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := c.DeleteConnection(ctx, con.ConnectionId); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	cron, err := c.CreateCron(ctx, client.CronCreate{
+		ConnectionId: con.ConnectionId,
+		Name:         fmt.Sprintf("round trip %d", time.Now().UnixNano()),
+		Command:      "SELECT name, load FROM hosts",
+		Schedule:     "minute",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := c.DeleteCron(ctx, cron.CronId); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if err := database.ExecuteCrons(db, cons); err != nil {
+		t.Fatal(err)
+	}
+	page, err := c.QueryCronData(ctx, cron.CronId, client.DataQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(page.Rows))
+	}
+	for _, row := range page.Rows {
+		if _, ok := row["load"]; !ok {
+			t.Fatalf("row without load: %v", row)
+		}
+	}
+}
@@ -35,7 +35,10 @@ func PostNewConnections(i *inertia.Inertia, db *database.Database, cons database
 		con, err := database.AddConnection(db, cons, body)
 		if err != nil {
 			slog.Error("Failed to create connection", slog.Any("error", err))
-			errs.Add("creation", errors.Unwrap(err))
+			if unwrapped := errors.Unwrap(err); unwrapped != nil {
+				err = unwrapped
+			}
+			errs.Add("creation", err)
 		}
 
 		if errs.HasErrors() {
@@ -51,18 +54,36 @@ func PostNewConnections(i *inertia.Inertia, db *database.Database, cons database
 	return i.Middleware(http.HandlerFunc(fn))
 }
 
+// queryTag returns the tag filter of the request, if any
+func queryTag(r *http.Request) *string {
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		return nil
+	}
+	return &tag
+}
+
 func GetConnections(i *inertia.Inertia, db *database.Database) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		errs := NewErrors(r)
+		tag := queryTag(r)
 
-		connections, err := database.GetConnections(db)
+		connections, err := database.GetConnections(db, tag)
 		if err != nil {
 			slog.Error("Failed to get connections", slog.Any("error", err))
 			errs.Add("connections", err)
 		}
 
+		tags, err := database.GetTags(db)
+		if err != nil {
+			slog.Error("Failed to get tags", slog.Any("error", err))
+			errs.Add("tags", err)
+		}
+
 		props := inertia.Props{
 			"connections": connections,
+			"tag":         tag,
+			"tags":        tags,
 		}
 
 		Render(w, errs.Request(r), i, "Home/Connections", props)
@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"d34d.one/grognon/internal/database"
 	"github.com/gorilla/mux"
@@ -18,10 +20,13 @@ func GetCrons(i *inertia.Inertia, db *database.Database) http.Handler {
 		errs := NewErrors(r)
 		vars := mux.Vars(r)
 
+		tag := queryTag(r)
 		props := inertia.Props{
 			"connectionId": nil,
 			"connection":   nil,
 			"crons":        nil,
+			"tag":          tag,
+			"tags":         nil,
 		}
 
 		connectionIdStr, ok := vars["connection_id"]
@@ -51,13 +56,20 @@ func GetCrons(i *inertia.Inertia, db *database.Database) http.Handler {
 			props["connection"] = connection
 		}
 
-		crons, err := database.GetCrons(db, connectionId)
+		crons, err := database.GetCrons(db, connectionId, tag)
 		if err != nil {
 			slog.Error("Failed to get crons", slog.Any("error", err))
 			errs.Add("crons", err)
 		}
 		props["crons"] = crons
 
+		tags, err := database.GetTags(db)
+		if err != nil {
+			slog.Error("Failed to get tags", slog.Any("error", err))
+			errs.Add("tags", err)
+		}
+		props["tags"] = tags
+
 		Render(w, errs.Request(r), i, "Home/Crons", props)
 	}
 
@@ -73,6 +85,7 @@ func GetNewCrons(i *inertia.Inertia, db *database.Database) http.Handler {
 			"connectionId": nil,
 			"connections":  nil,
 			"columns":      nil,
+			"tags":         nil,
 		}
 
 		connectionIdStr, ok := vars["connection_id"]
@@ -99,7 +112,7 @@ func GetNewCrons(i *inertia.Inertia, db *database.Database) http.Handler {
 			props["columns"] = columns
 		}
 
-		connections, err := database.GetConnections(db)
+		connections, err := database.GetConnections(db, nil)
 		if err != nil {
 			slog.Error("Failed to get connections", slog.Any("error", err))
 			errs.Add("connections", err)
@@ -108,6 +121,13 @@ func GetNewCrons(i *inertia.Inertia, db *database.Database) http.Handler {
 		}
 		props["connections"] = connections
 
+		tags, err := database.GetTags(db)
+		if err != nil {
+			slog.Error("Failed to get tags", slog.Any("error", err))
+			errs.Add("tags", err)
+		}
+		props["tags"] = tags
+
 		Render(w, errs.Request(r), i, "Home/CronsCreate", props)
 	}
 
@@ -128,7 +148,10 @@ func PostNewCrons(i *inertia.Inertia, db *database.Database, cons database.Conne
 		cron, err := database.AddCron(db, cons, body)
 		if err != nil {
 			slog.Error("Failed to create cron", slog.Any("error", err))
-			errs.Add("creation", errors.Unwrap(err))
+			if unwrapped := errors.Unwrap(err); unwrapped != nil {
+				err = unwrapped
+			}
+			errs.Add("creation", err)
 		}
 
 		if errs.HasErrors() {
@@ -144,6 +167,28 @@ func PostNewCrons(i *inertia.Inertia, db *database.Database, cons database.Conne
 	return i.Middleware(http.HandlerFunc(fn))
 }
 
+func PostCronPreview(db *database.Database, cons database.Connections) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var body database.CronCreate
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			slog.Error("Failed to decode request body", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		preview, err := database.PreviewCron(db, cons, body)
+		if err != nil {
+			slog.Error("Failed to preview cron", slog.Any("error", err))
+			writeJSONError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, preview)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
 func GetCron(i *inertia.Inertia, db *database.Database) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		errs := NewErrors(r)
@@ -183,10 +228,48 @@ func GetCron(i *inertia.Inertia, db *database.Database) http.Handler {
 			return
 		}
 
+		connectionIds, err := database.GetCronConnections(db, *cron)
+		if err != nil {
+			slog.Error("Failed to get cron connections", slog.Any("error", err))
+			errs.Add("connections", err)
+			Render(w, errs.Request(r), i, "Home/Cron", nil)
+			return
+		}
+
+		rollups, err := database.GetCronRollups(db, cronId)
+		if err != nil {
+			slog.Error("Failed to get cron rollups", slog.Any("error", err))
+			errs.Add("rollups", err)
+		}
+
+		stats, err := database.GetCronStats(db, cron.Slug)
+		if err != nil {
+			slog.Error("Failed to get cron stats", slog.Any("error", err))
+			errs.Add("stats", err)
+		}
+
+		detectors, err := database.GetAnomalyDetectors(db, cronId)
+		if err != nil {
+			slog.Error("Failed to get anomaly detectors", slog.Any("error", err))
+			errs.Add("detectors", err)
+		}
+
+		anomalies, err := database.GetAnomalies(db, cronId, anomalyListSize)
+		if err != nil {
+			slog.Error("Failed to get anomalies", slog.Any("error", err))
+			errs.Add("anomalies", err)
+		}
+
 		props := inertia.Props{
-			"cron":        cron,
-			"connection":  connection,
-			"cronOutputs": outputs,
+			"cron":          cron,
+			"connection":    connection,
+			"connectionIds": connectionIds,
+			"cronOutputs":   outputs,
+			"rollups":       rollups,
+			"stats":         stats,
+			"retentionDays": int64(cron.Retention(db.Settings).Hours() / 24),
+			"detectors":     detectors,
+			"anomalies":     anomalies,
 		}
 
 		Render(w, errs.Request(r), i, "Home/Cron", props)
@@ -204,7 +287,7 @@ func GetCronData(i *inertia.Inertia, db *database.Database) http.Handler {
 			"cronId":      nil,
 			"cronOutputs": nil,
 			"cron":        nil,
-			"data":        nil,
+			"rollups":     nil,
 		}
 
 		cronId, err := strconv.ParseInt(vars["cron_id"], 10, 64)
@@ -235,15 +318,13 @@ func GetCronData(i *inertia.Inertia, db *database.Database) http.Handler {
 		}
 		props["cronOutputs"] = outputs
 
-		data, err := database.GetCronData(db, cron.Slug)
+		// The data itself is loaded by the page from the query endpoint
+		rollups, err := database.GetCronRollups(db, cronId)
 		if err != nil {
-			slog.Error("Failed to get cron data", slog.Any("error", err))
-			errs.Add("data", err)
-
-			Render(w, errs.Request(r), i, "Home/CronData", props)
-			return
+			slog.Error("Failed to get cron rollups", slog.Any("error", err))
+			errs.Add("rollups", err)
 		}
-		props["data"] = data
+		props["rollups"] = rollups
 
 		Render(w, errs.Request(r), i, "Home/CronData", props)
 	}
@@ -251,6 +332,112 @@ func GetCronData(i *inertia.Inertia, db *database.Database) http.Handler {
 	return i.Middleware(http.HandlerFunc(fn))
 }
 
+// parseBucket reads a bucket length, Go durations with days support
+func parseBucket(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid bucket: %w", err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	bucket, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bucket: %w", err)
+	}
+	return bucket, nil
+}
+
+// parseDataQuery reads the query parameters of the data endpoints:
+// from, to, columns, label.<name>, bucket, agg, limit and cursor
+func parseDataQuery(r *http.Request) (database.DataQuery, error) {
+	query := r.URL.Query()
+	var q database.DataQuery
+	var err error
+
+	q.From, q.To, err = parseTimeRange(r)
+	if err != nil {
+		return q, err
+	}
+
+	if columns := query.Get("columns"); columns != "" {
+		q.Columns = strings.Split(columns, ",")
+	}
+
+	q.Labels = make(map[string]string)
+	for key, values := range query {
+		if label, ok := strings.CutPrefix(key, "label."); ok && len(values) > 0 {
+			q.Labels[label] = values[0]
+		}
+	}
+
+	if bucket := query.Get("bucket"); bucket != "" {
+		q.Bucket, err = parseBucket(bucket)
+		if err != nil {
+			return q, err
+		}
+		q.Agg = query.Get("agg")
+		if q.Agg == "" {
+			q.Agg = "avg"
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return q, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+	q.Cursor = query.Get("cursor")
+
+	return q, nil
+}
+
+func GetCronQuery(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		cronId, err := strconv.ParseInt(vars["cron_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse cron id", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		q, err := parseDataQuery(r)
+		if err != nil {
+			slog.Error("Failed to parse data query", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		cron, err := database.GetCron(db, cronId)
+		if err != nil {
+			slog.Error("Failed to get cron", slog.Any("error", err))
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+
+		outputs, err := database.GetCronOutputs(db, cronId)
+		if err != nil {
+			slog.Error("Failed to get cron outputs", slog.Any("error", err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		page, err := database.QueryCronData(db, *cron, outputs, q)
+		if err != nil {
+			slog.Error("Failed to query cron data", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
 func DeleteCrons(i *inertia.Inertia, db *database.Database) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		errs := NewErrors(r)
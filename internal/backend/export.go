@@ -0,0 +1,276 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Rows kept in memory by the parquet writer before a row group is flushed
+const parquetRowGroupSize = 10_000
+
+// exportWriter is a database.DataWriter for one of the export formats,
+// Started reports whether the response headers were sent
+type exportWriter interface {
+	database.DataWriter
+	Close() error
+	Started() bool
+}
+
+func setExportHeaders(w http.ResponseWriter, contentType string, filename string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+}
+
+func exportValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type csvExport struct {
+	w        http.ResponseWriter
+	filename string
+	csv      *csv.Writer
+	record   []string
+}
+
+func (e *csvExport) Begin(cols []*sql.ColumnType) error {
+	setExportHeaders(e.w, "text/csv", e.filename)
+	e.csv = csv.NewWriter(e.w)
+	e.record = make([]string, len(cols))
+	for i, col := range cols {
+		e.record[i] = col.Name()
+	}
+	return e.csv.Write(e.record)
+}
+
+func (e *csvExport) Write(values []interface{}) error {
+	for i, value := range values {
+		e.record[i] = exportValue(value)
+	}
+	return e.csv.Write(e.record)
+}
+
+func (e *csvExport) Close() error {
+	if e.csv == nil {
+		return nil
+	}
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+func (e *csvExport) Started() bool { return e.csv != nil }
+
+type jsonlExport struct {
+	w        http.ResponseWriter
+	filename string
+	enc      *json.Encoder
+	cols     []string
+}
+
+func (e *jsonlExport) Begin(cols []*sql.ColumnType) error {
+	setExportHeaders(e.w, "application/jsonl", e.filename)
+	e.enc = json.NewEncoder(e.w)
+	for _, col := range cols {
+		e.cols = append(e.cols, col.Name())
+	}
+	return nil
+}
+
+func (e *jsonlExport) Write(values []interface{}) error {
+	object := make(database.Object, len(values))
+	for i, value := range values {
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		object[e.cols[i]] = value
+	}
+	return e.enc.Encode(object)
+}
+
+func (e *jsonlExport) Close() error { return nil }
+
+func (e *jsonlExport) Started() bool { return e.enc != nil }
+
+type parquetExport struct {
+	w        http.ResponseWriter
+	filename string
+	writer   *parquet.Writer
+	// Parquet column index of every query column, the schema sorts them by name
+	indexes []int
+	kinds   []parquet.Kind
+	row     parquet.Row
+}
+
+// parquetNode maps the Postgres type of a column, anything unknown is written as text
+func parquetNode(col *sql.ColumnType) parquet.Node {
+	switch col.DatabaseTypeName() {
+	case "INT2", "INT4", "INT8":
+		return parquet.Optional(parquet.Int(64))
+	case "FLOAT4", "FLOAT8":
+		return parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	case "TIMESTAMP", "TIMESTAMPTZ":
+		return parquet.Optional(parquet.Timestamp(parquet.Microsecond))
+	default:
+		return parquet.Optional(parquet.String())
+	}
+}
+
+func (e *parquetExport) Begin(cols []*sql.ColumnType) error {
+	group := parquet.Group{}
+	for _, col := range cols {
+		group[col.Name()] = parquetNode(col)
+	}
+	schema := parquet.NewSchema("cron_data", group)
+
+	positions := make(map[string]int)
+	for i, path := range schema.Columns() {
+		positions[path[0]] = i
+	}
+	e.indexes = make([]int, len(cols))
+	e.kinds = make([]parquet.Kind, len(cols))
+	for i, col := range cols {
+		e.indexes[i] = positions[col.Name()]
+		e.kinds[i] = group[col.Name()].Type().Kind()
+	}
+	e.row = make(parquet.Row, len(cols))
+
+	setExportHeaders(e.w, "application/vnd.apache.parquet", e.filename)
+	e.writer = parquet.NewWriter(e.w, schema, parquet.MaxRowsPerRowGroup(parquetRowGroupSize))
+	return nil
+}
+
+func (e *parquetExport) Write(values []interface{}) error {
+	for i, value := range values {
+		v, err := parquetValue(e.kinds[i], value)
+		if err != nil {
+			return err
+		}
+		definition := 1
+		if v.IsNull() {
+			definition = 0
+		}
+		e.row[e.indexes[i]] = v.Level(0, definition, e.indexes[i])
+	}
+	_, err := e.writer.WriteRows([]parquet.Row{e.row})
+	return err
+}
+
+func parquetValue(kind parquet.Kind, value interface{}) (parquet.Value, error) {
+	if value == nil {
+		return parquet.NullValue(), nil
+	}
+	switch kind {
+	case parquet.Int64:
+		switch v := value.(type) {
+		case int64:
+			return parquet.Int64Value(v), nil
+		case time.Time:
+			return parquet.Int64Value(v.UnixMicro()), nil
+		}
+	case parquet.Double:
+		switch v := value.(type) {
+		case float64:
+			return parquet.DoubleValue(v), nil
+		case float32:
+			return parquet.DoubleValue(float64(v)), nil
+		}
+	default:
+		return parquet.ByteArrayValue([]byte(exportValue(value))), nil
+	}
+	return parquet.Value{}, fmt.Errorf("unexpected %T value for a %s column", value, kind)
+}
+
+func (e *parquetExport) Close() error {
+	if e.writer == nil {
+		return nil
+	}
+	return e.writer.Close()
+}
+
+func (e *parquetExport) Started() bool { return e.writer != nil }
+
+func newExportWriter(w http.ResponseWriter, format string, filename string) (exportWriter, error) {
+	switch format {
+	case "csv":
+		return &csvExport{w: w, filename: filename}, nil
+	case "jsonl":
+		return &jsonlExport{w: w, filename: filename}, nil
+	case "parquet":
+		return &parquetExport{w: w, filename: filename}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %s", format)
+}
+
+// GetCronExport streams the samples of a cron as a file, with the filters of the data view
+func GetCronExport(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		cronId, err := strconv.ParseInt(vars["cron_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse cron id", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		q, err := parseDataQuery(r)
+		if err != nil {
+			slog.Error("Failed to parse data query", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		cron, err := database.GetCron(db, cronId)
+		if err != nil {
+			slog.Error("Failed to get cron", slog.Any("error", err))
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+
+		outputs, err := database.GetCronOutputs(db, cronId)
+		if err != nil {
+			slog.Error("Failed to get cron outputs", slog.Any("error", err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		export, err := newExportWriter(w, vars["format"], fmt.Sprintf("%s.%s", cron.Slug, vars["format"]))
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+
+		err = database.StreamCronData(r.Context(), db, *cron, outputs, q, export)
+		if err == nil {
+			err = export.Close()
+		}
+		if err != nil {
+			slog.Error("Failed to export cron data", slog.Any("error", err))
+			// Once the file started the status can't change anymore, the download is cut short
+			if !export.Started() {
+				writeJSONError(w, http.StatusBadRequest, err)
+			}
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
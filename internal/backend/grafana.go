@@ -0,0 +1,402 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"d34d.one/grognon/internal/database"
+)
+
+// Endpoints of the Grafana JSON API (SimpleJSON) datasource, targets are named
+// <cron slug>.<column>, optionally suffixed by :<aggregation>
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaTarget struct {
+	Target string `json:"target"`
+	RefId  string `json:"refId"`
+	Type   string `json:"type"`
+}
+
+type grafanaFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+type grafanaQuery struct {
+	Range         grafanaRange    `json:"range"`
+	IntervalMs    int64           `json:"intervalMs"`
+	MaxDataPoints int64           `json:"maxDataPoints"`
+	Targets       []grafanaTarget `json:"targets"`
+	AdhocFilters  []grafanaFilter `json:"adhocFilters"`
+}
+
+type grafanaTimeserie struct {
+	Target     string           `json:"target"`
+	Datapoints [][2]interface{} `json:"datapoints"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type grafanaText struct {
+	Type string `json:"type,omitempty"`
+	Text string `json:"text"`
+}
+
+// grafanaSeries is a database.DataWriter splitting the rows of a bucketed query by label set
+type grafanaSeries struct {
+	target string
+	column string
+
+	timeIdx    int
+	valueIdx   int
+	labelIdxs  []int
+	labelNames []string
+	series     map[string]*grafanaTimeserie
+	order      []string
+	table      grafanaTable
+}
+
+func (s *grafanaSeries) Begin(cols []*sql.ColumnType) error {
+	s.series = make(map[string]*grafanaTimeserie)
+	s.table = grafanaTable{Type: "table", Columns: []grafanaColumn{{"Time", "time"}}, Rows: [][]interface{}{}}
+	for i, col := range cols {
+		switch {
+		case col.Name() == "bucket":
+			s.timeIdx = i
+		case col.Name() == database.ColumnName(s.column):
+			s.valueIdx = i
+		default:
+			s.labelIdxs = append(s.labelIdxs, i)
+			s.labelNames = append(s.labelNames, col.Name())
+			s.table.Columns = append(s.table.Columns, grafanaColumn{col.Name(), "string"})
+		}
+	}
+	s.table.Columns = append(s.table.Columns, grafanaColumn{s.column, "number"})
+	return nil
+}
+
+func (s *grafanaSeries) Write(values []interface{}) error {
+	t, ok := values[s.timeIdx].(time.Time)
+	if !ok {
+		return fmt.Errorf("unexpected bucket type %T", values[s.timeIdx])
+	}
+	ms := t.UnixMilli()
+
+	var labels []string
+	row := []interface{}{ms}
+	for j, i := range s.labelIdxs {
+		labels = append(labels, fmt.Sprintf("%s=%v", s.labelNames[j], values[i]))
+		row = append(row, values[i])
+	}
+	row = append(row, values[s.valueIdx])
+	s.table.Rows = append(s.table.Rows, row)
+
+	name := s.target
+	if len(labels) > 0 {
+		name += "{" + strings.Join(labels, ",") + "}"
+	}
+	serie, ok := s.series[name]
+	if !ok {
+		serie = &grafanaTimeserie{Target: name, Datapoints: [][2]interface{}{}}
+		s.series[name] = serie
+		s.order = append(s.order, name)
+	}
+	serie.Datapoints = append(serie.Datapoints, [2]interface{}{values[s.valueIdx], ms})
+	return nil
+}
+
+// timeseries are sorted oldest first, the queries return the most recent rows first
+func (s *grafanaSeries) timeseries() []grafanaTimeserie {
+	var timeseries []grafanaTimeserie
+	for _, name := range s.order {
+		serie := s.series[name]
+		slices.Reverse(serie.Datapoints)
+		timeseries = append(timeseries, *serie)
+	}
+	return timeseries
+}
+
+// grafanaBucket keeps the number of points under maxDataPoints, in whole seconds
+func grafanaBucket(q grafanaQuery) time.Duration {
+	bucket := time.Duration(q.IntervalMs) * time.Millisecond
+	if q.MaxDataPoints > 0 {
+		bucket = max(bucket, q.Range.To.Sub(q.Range.From)/time.Duration(q.MaxDataPoints))
+	}
+	return max(bucket.Round(time.Second), time.Second)
+}
+
+func queryGrafanaTarget(r *http.Request, db *database.Database, q grafanaQuery, target grafanaTarget) (*grafanaSeries, error) {
+	name, agg, _ := strings.Cut(target.Target, ":")
+	if agg == "" {
+		agg = "avg"
+	}
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return nil, fmt.Errorf("invalid target %s, expected <cron>.<column>", target.Target)
+	}
+	slug, column := name[:dot], name[dot+1:]
+
+	cron, err := database.GetCronBySlug(db, slug)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := database.GetCronOutputs(db, cron.CronId)
+	if err != nil {
+		return nil, err
+	}
+	labels := cron.Labels(outputs)
+
+	dq := database.DataQuery{
+		From:    q.Range.From,
+		To:      q.Range.To,
+		Columns: append(slices.Clone(labels), column),
+		Labels:  make(map[string]string),
+		Bucket:  grafanaBucket(q),
+		Agg:     agg,
+	}
+	// Ad hoc filters apply to the crons having the label
+	for _, filter := range q.AdhocFilters {
+		if !slices.Contains(labels, filter.Key) {
+			continue
+		}
+		if filter.Operator != "=" {
+			return nil, fmt.Errorf("unsupported filter operator %s", filter.Operator)
+		}
+		dq.Labels[filter.Key] = filter.Value
+	}
+
+	series := &grafanaSeries{target: name, column: column}
+	if err := database.StreamCronData(r.Context(), db, *cron, outputs, dq, series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+func decodeGrafana(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		slog.Error("Failed to decode request body", slog.Any("error", err))
+		writeJSONError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// GetGrafana answers the connection test of the datasource
+func GetGrafana() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// PostGrafanaSearch lists the numeric columns of the crons as targets
+func PostGrafanaSearch(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target string `json:"target"`
+		}
+		if !decodeGrafana(w, r, &body) {
+			return
+		}
+
+		crons, err := database.GetCrons(db, nil, nil)
+		if err != nil {
+			slog.Error("Failed to get crons", slog.Any("error", err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		targets := []string{}
+		for _, cron := range crons {
+			outputs, err := database.GetCronOutputs(db, cron.CronId)
+			if err != nil {
+				slog.Error("Failed to get cron outputs", slog.Any("error", err))
+				writeJSONError(w, http.StatusInternalServerError, err)
+				return
+			}
+			for _, output := range outputs {
+				target := cron.Slug + "." + output.Name
+				if output.IsNumeric() && strings.Contains(target, body.Target) {
+					targets = append(targets, target)
+				}
+			}
+		}
+
+		writeJSON(w, http.StatusOK, targets)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// PostGrafanaQuery answers timeserie and table targets with bucketed queries
+func PostGrafanaQuery(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var body grafanaQuery
+		if !decodeGrafana(w, r, &body) {
+			return
+		}
+
+		response := []interface{}{}
+		for _, target := range body.Targets {
+			if target.Target == "" {
+				continue
+			}
+			series, err := queryGrafanaTarget(r, db, body, target)
+			if err != nil {
+				slog.Error("Failed to query grafana target", slog.String("target", target.Target), slog.Any("error", err))
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			if target.Type == "table" {
+				response = append(response, series.table)
+				continue
+			}
+			for _, serie := range series.timeseries() {
+				response = append(response, serie)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+type grafanaAnnotationQuery struct {
+	Range      grafanaRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Annotation interface{} `json:"annotation"`
+	Time       int64       `json:"time"`
+	TimeEnd    int64       `json:"timeEnd,omitempty"`
+	IsRegion   bool        `json:"isRegion"`
+	Title      string      `json:"title"`
+	Text       string      `json:"text"`
+	Tags       []string    `json:"tags"`
+}
+
+// PostGrafanaAnnotations lists the annotations of the range, the query holds an
+// optional cron slug and #tag terms the annotations must all have
+func PostGrafanaAnnotations(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var body grafanaAnnotationQuery
+		if !decodeGrafana(w, r, &body) {
+			return
+		}
+
+		q := database.AnnotationQuery{From: body.Range.From, To: body.Range.To}
+		for _, term := range strings.Fields(body.Annotation.Query) {
+			if tag, ok := strings.CutPrefix(term, "#"); ok {
+				q.Tags = append(q.Tags, tag)
+				continue
+			}
+			cron, err := database.GetCronBySlug(db, term)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			q.CronId = &cron.CronId
+		}
+
+		annotations, err := database.GetAnnotations(db, q)
+		if err != nil {
+			slog.Error("Failed to get annotations", slog.Any("error", err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response := []grafanaAnnotation{}
+		for _, annotation := range annotations {
+			item := grafanaAnnotation{
+				Annotation: body.Annotation,
+				Time:       annotation.Time.UnixMilli(),
+				Title:      annotation.Text,
+				Text:       annotation.Text,
+				Tags:       annotation.Tags,
+			}
+			if item.Tags == nil {
+				item.Tags = []string{}
+			}
+			if annotation.TimeEnd != nil {
+				item.TimeEnd = annotation.TimeEnd.UnixMilli()
+				item.IsRegion = true
+			}
+			response = append(response, item)
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// PostGrafanaTagKeys lists the label columns usable as ad hoc filters
+func PostGrafanaTagKeys(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		names, err := database.GetLabelNames(db)
+		if err != nil {
+			slog.Error("Failed to get label names", slog.Any("error", err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		keys := []grafanaText{}
+		for _, name := range names {
+			keys = append(keys, grafanaText{"string", name})
+		}
+		writeJSON(w, http.StatusOK, keys)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func PostGrafanaTagValues(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Key string `json:"key"`
+		}
+		if !decodeGrafana(w, r, &body) {
+			return
+		}
+
+		values, err := database.GetLabelValues(db, body.Key)
+		if err != nil {
+			slog.Error("Failed to get label values", slog.Any("error", err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		texts := []grafanaText{}
+		for _, value := range values {
+			texts = append(texts, grafanaText{Text: value})
+		}
+		writeJSON(w, http.StatusOK, texts)
+	}
+
+	return http.HandlerFunc(fn)
+}
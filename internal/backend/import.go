@@ -0,0 +1,102 @@
+package backend
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+)
+
+// Uploads bigger than this are spooled to disk by the multipart reader
+const importMemory = 32 << 20
+
+// parseCronImport reads the form fields of an import: timestamp_column,
+// time_format (repeated), map.<csv column>=<output> and dry_run
+func parseCronImport(r *http.Request) (database.CronImport, error) {
+	opts := database.CronImport{
+		TimestampColumn: r.FormValue("timestamp_column"),
+		Mapping:         make(map[string]string),
+	}
+	for _, format := range r.Form["time_format"] {
+		if format = strings.TrimSpace(format); format != "" {
+			opts.TimeFormats = append(opts.TimeFormats, format)
+		}
+	}
+	for key, values := range r.Form {
+		if col, ok := strings.CutPrefix(key, "map."); ok && len(values) > 0 && values[0] != "" {
+			opts.Mapping[col] = values[0]
+		}
+	}
+	if dryRun := r.FormValue("dry_run"); dryRun != "" {
+		var err error
+		opts.DryRun, err = strconv.ParseBool(dryRun)
+		if err != nil {
+			return opts, fmt.Errorf("invalid dry_run: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// PostCronImport loads the samples of an uploaded CSV file into a cron
+func PostCronImport(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		cronId, err := strconv.ParseInt(vars["cron_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse cron id", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := r.ParseMultipartForm(importMemory); err != nil {
+			slog.Error("Failed to parse import form", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		opts, err := parseCronImport(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			slog.Error("Failed to read import file", slog.Any("error", err))
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				slog.Error("Failed to close import file", slog.Any("error", err))
+			}
+		}()
+
+		cron, err := database.GetCron(db, cronId)
+		if err != nil {
+			slog.Error("Failed to get cron", slog.Any("error", err))
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+		outputs, err := database.GetCronOutputs(db, cronId)
+		if err != nil {
+			slog.Error("Failed to get cron outputs", slog.Any("error", err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		report, err := database.ImportCronCSV(r.Context(), db, *cron, outputs, file, opts)
+		if err != nil {
+			slog.Error("Failed to import cron data", slog.Any("error", err))
+			writeJSONError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, report)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"d34d.one/grognon/internal/database"
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func metricValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), true
+	default:
+		return "", false
+	}
+}
+
+// writeMetrics ignores the write errors, the writer keeps the first one and Flush reports it.
+// Names maps the metric names already written to the cron they come from.
+func writeMetrics(w *bufio.Writer, samples database.LatestSamples, names map[string]int64) {
+	for _, metric := range samples.Metrics {
+		name := database.PromName(samples.Cron.Slug, metric.Name)
+		// Different slugs and outputs can make the same name, Prometheus rejects
+		// the whole scrape on a metric described twice so the first one wins
+		if cronId, ok := names[name]; ok {
+			slog.Warn("Skipping colliding metric", slog.String("metric", name), slog.Int64("id", samples.Cron.CronId), slog.Int64("first_id", cronId))
+			continue
+		}
+		names[name] = samples.Cron.CronId
+		_, _ = fmt.Fprintf(w, "# HELP %s %s of cron %s\n", name, metric.Name, labelEscaper.Replace(samples.Cron.Name))
+		_, _ = fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+
+		// Prometheus rejects the whole scrape on duplicated series, the first row wins
+		seen := make(map[string]bool)
+		for _, row := range samples.Rows {
+			value, ok := metricValue(row[metric.Column()])
+			if !ok {
+				continue
+			}
+			var labels []string
+			for _, label := range samples.Labels {
+				if v := row[database.ColumnName(label)]; v != nil {
+					labels = append(labels, fmt.Sprintf(`%s="%s"`, database.PromName(label), labelEscaper.Replace(fmt.Sprint(v))))
+				}
+			}
+			series := strings.Join(labels, ",")
+			if seen[series] {
+				slog.Debug("Skipping duplicated series", slog.String("metric", name), slog.String("labels", series))
+				continue
+			}
+			seen[series] = true
+			timestamp, _ := row["timestamp"].(time.Time)
+
+			_, _ = w.WriteString(name)
+			if len(labels) > 0 {
+				_, _ = fmt.Fprintf(w, "{%s}", series)
+			}
+			_, _ = fmt.Fprintf(w, " %s %d\n", value, timestamp.UnixMilli())
+		}
+	}
+}
+
+// GetMetrics exposes the latest numeric outputs of the crons in the Prometheus text format
+func GetMetrics(db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		samples, err := database.GetLatestSamples(db)
+		if err != nil {
+			slog.Error("Failed to get latest samples", slog.Any("error", err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf := bufio.NewWriter(w)
+		names := make(map[string]int64)
+		for _, s := range samples {
+			writeMetrics(buf, s, names)
+		}
+		if err := buf.Flush(); err != nil {
+			slog.Error("Failed to write metrics", slog.Any("error", err))
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
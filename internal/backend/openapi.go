@@ -0,0 +1,228 @@
+package backend
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The description of the /api/v1 routes, it must be updated along with setupAPI,
+// which openapi_test.go checks
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+type openAPIDocument struct {
+	Servers []struct {
+		Url string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Format     string                    `json:"format"`
+	Nullable   bool                      `json:"nullable"`
+	Required   []string                  `json:"required"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Items      *openAPISchema            `json:"items"`
+}
+
+var openAPIMethods = []string{"get", "post", "put", "patch", "delete"}
+
+func GetOpenAPI() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(openAPISpec); err != nil {
+			slog.Error("Failed to write openapi specification", slog.Any("error", err))
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// checkOpenAPISchemas compares the component schemas of the specification to the Go
+// types encoded or decoded by the handlers, all of the schemas must be given a type
+func checkOpenAPISchemas(types map[string]reflect.Type) error {
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		return fmt.Errorf("invalid openapi specification: %w", err)
+	}
+
+	var drift []string
+	for name, schema := range doc.Components.Schemas {
+		t, ok := types[name]
+		if !ok {
+			drift = append(drift, name+": no Go type")
+			continue
+		}
+		drift = append(drift, compareSchema(doc, name, schema, t)...)
+	}
+	if len(drift) > 0 {
+		slices.Sort(drift)
+		return fmt.Errorf("openapi schemas out of sync with the go types: %s", strings.Join(drift, ", "))
+	}
+	return nil
+}
+
+// compareSchema lists the differences between a schema and a Go type, pointers are
+// nullable and slices may be
+func compareSchema(doc openAPIDocument, path string, schema *openAPISchema, t reflect.Type) []string {
+	if schema.Ref != "" {
+		ref, ok := doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return []string{path + ": unknown reference " + schema.Ref}
+		}
+		schema = ref
+	}
+
+	var drift []string
+	pointer := t.Kind() == reflect.Pointer
+	if pointer {
+		t = t.Elem()
+	}
+	if pointer != schema.Nullable && t.Kind() != reflect.Slice && t.Kind() != reflect.Map {
+		drift = append(drift, fmt.Sprintf("%s: nullable is %t for %s", path, schema.Nullable, t))
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		if schema.Type != "string" || schema.Format != "date-time" {
+			drift = append(drift, fmt.Sprintf("%s: %s is not a date-time string", path, schema.Type))
+		}
+	case t.Kind() == reflect.Bool:
+		if schema.Type != "boolean" {
+			drift = append(drift, fmt.Sprintf("%s: %s is not a boolean", path, schema.Type))
+		}
+	case t.Kind() == reflect.String:
+		if schema.Type != "string" {
+			drift = append(drift, fmt.Sprintf("%s: %s is not a string", path, schema.Type))
+		}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		if schema.Type != "integer" {
+			drift = append(drift, fmt.Sprintf("%s: %s is not an integer", path, schema.Type))
+		}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		if schema.Type != "number" {
+			drift = append(drift, fmt.Sprintf("%s: %s is not a number", path, schema.Type))
+		}
+	case t.Kind() == reflect.Slice:
+		if schema.Type != "array" || schema.Items == nil {
+			drift = append(drift, fmt.Sprintf("%s: %s is not an array", path, schema.Type))
+			break
+		}
+		drift = append(drift, compareSchema(doc, path+"[]", schema.Items, t.Elem())...)
+	case t.Kind() == reflect.Map:
+		if schema.Type != "object" {
+			drift = append(drift, fmt.Sprintf("%s: %s is not an object", path, schema.Type))
+		}
+	case t.Kind() == reflect.Struct:
+		if schema.Type != "object" {
+			drift = append(drift, fmt.Sprintf("%s: %s is not an object", path, schema.Type))
+			break
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fields[name] = field.Type
+		}
+		for name, fieldType := range fields {
+			property, ok := schema.Properties[name]
+			if !ok {
+				drift = append(drift, fmt.Sprintf("%s.%s: not documented", path, name))
+				continue
+			}
+			drift = append(drift, compareSchema(doc, path+"."+name, property, fieldType)...)
+		}
+		for name := range schema.Properties {
+			if _, ok := fields[name]; !ok {
+				drift = append(drift, fmt.Sprintf("%s.%s: no such field", path, name))
+			}
+		}
+		for _, name := range schema.Required {
+			if fieldType, ok := fields[name]; !ok || fieldType.Kind() == reflect.Pointer {
+				drift = append(drift, fmt.Sprintf("%s.%s: required but optional", path, name))
+			}
+		}
+	default:
+		drift = append(drift, fmt.Sprintf("%s: unsupported type %s", path, t))
+	}
+	return drift
+}
+
+// checkOpenAPI compares the operations of the specification to the routes of the API router
+func checkOpenAPI(api *mux.Router) error {
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		return fmt.Errorf("invalid openapi specification: %w", err)
+	}
+	if len(doc.Servers) != 1 {
+		return fmt.Errorf("openapi specification must have a single server")
+	}
+	prefix := doc.Servers[0].Url
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			if slices.Contains(openAPIMethods, method) {
+				documented[strings.ToUpper(method)+" "+prefix+path] = true
+			}
+		}
+	}
+
+	routed := make(map[string]bool)
+	err := api.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routed[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for operation := range routed {
+		if !documented[operation] {
+			missing = append(missing, operation)
+		}
+	}
+	for operation := range documented {
+		if !routed[operation] {
+			missing = append(missing, operation+" (not routed)")
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return fmt.Errorf("openapi specification out of sync with the api routes: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
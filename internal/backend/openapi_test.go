@@ -0,0 +1,35 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	api := setupAPI(mux.NewRouter(), nil, nil)
+	if err := checkOpenAPI(api); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPIMatchesTypes(t *testing.T) {
+	types := map[string]reflect.Type{
+		"ErrorResponse": reflect.TypeOf(struct {
+			Error APIError `json:"error"`
+		}{}),
+		"ConnectionCreate": reflect.TypeOf(database.ConnectionCreate{}),
+		"Connection":       reflect.TypeOf(database.Connection{}),
+		"Column":           reflect.TypeOf(database.Column{}),
+		"CronCreate":       reflect.TypeOf(database.CronCreate{}),
+		"Cron":             reflect.TypeOf(database.Cron{}),
+		"CronOutput":       reflect.TypeOf(database.CronOutput{}),
+		"CronRun":          reflect.TypeOf(database.CronRun{}),
+		"DataPage":         reflect.TypeOf(database.DataPage{}),
+	}
+	if err := checkOpenAPISchemas(types); err != nil {
+		t.Fatal(err)
+	}
+}
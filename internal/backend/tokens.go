@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+	inertia "github.com/romsar/gonertia"
+)
+
+// tokensProps lists the tokens, secret is the one of a token that was just created
+func tokensProps(db *database.Database, errs *Errors, secret interface{}) inertia.Props {
+	tokens, err := database.GetAPITokens(db)
+	if err != nil {
+		slog.Error("Failed to get api tokens", slog.Any("error", err))
+		errs.Add("tokens", err)
+	}
+
+	return inertia.Props{
+		"tokens": tokens,
+		"secret": secret,
+		"kinds":  database.TokenKinds,
+		"scopes": database.TokenScopes,
+	}
+}
+
+func GetTokens(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		props := tokensProps(db, errs, nil)
+		Render(w, errs.Request(r), i, "Home/Tokens", props)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func PostNewTokens(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+
+		var body database.APITokenCreate
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			slog.Error("Failed to decode request body", slog.Any("error", err))
+			errs.Add("body", err)
+		}
+
+		var secret string
+		if !errs.HasErrors() {
+			_, secret, err = database.AddAPIToken(db, body)
+			if err != nil {
+				slog.Error("Failed to create api token", slog.Any("error", err))
+				if unwrapped := errors.Unwrap(err); unwrapped != nil {
+					err = unwrapped
+				}
+				errs.Add("creation", err)
+			}
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+			i.Back(w, r)
+			SaveSession(w, r)
+			return
+		}
+		// The secret is only shown once, in the response to its creation, it
+		// never goes through the session cookie
+		props := tokensProps(db, errs, secret)
+		Render(w, errs.Request(r), i, "Home/Tokens", props)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func DeleteToken(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		tokenId, err := strconv.ParseInt(vars["token_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse token id", slog.Any("error", err))
+			errs.Add("input", err)
+		} else if err := database.RevokeAPIToken(db, tokenId); err != nil {
+			slog.Error("Failed to revoke api token", slog.Any("error", err))
+			errs.Add("revocation", err)
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+			i.Back(w, r)
+		} else {
+			i.Redirect(w, r, "/tokens")
+		}
+		SaveSession(w, r)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
@@ -0,0 +1,158 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/gorilla/mux"
+	inertia "github.com/romsar/gonertia"
+)
+
+// Entries of the delivery log shown on a webhook page
+const deliveryLogSize = 100
+
+func GetWebhooks(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+
+		webhooks, err := database.GetWebhooks(db, nil)
+		if err != nil {
+			slog.Error("Failed to get webhooks", slog.Any("error", err))
+			errs.Add("webhooks", err)
+		}
+
+		crons, err := database.GetCrons(db, nil, nil)
+		if err != nil {
+			slog.Error("Failed to get crons", slog.Any("error", err))
+			errs.Add("crons", err)
+		}
+
+		props := inertia.Props{
+			"webhooks": webhooks,
+			"crons":    crons,
+			"events":   database.EventTypes,
+		}
+
+		Render(w, errs.Request(r), i, "Home/Webhooks", props)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func PostNewWebhooks(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+
+		var body database.WebhookCreate
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			slog.Error("Failed to decode request body", slog.Any("error", err))
+			errs.Add("body", err)
+		}
+
+		webhook, err := database.AddWebhook(db, body)
+		if err != nil {
+			slog.Error("Failed to create webhook", slog.Any("error", err))
+			if unwrapped := errors.Unwrap(err); unwrapped != nil {
+				err = unwrapped
+			}
+			errs.Add("creation", err)
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+			i.Back(w, r)
+		} else {
+			url := fmt.Sprintf("/webhooks/%d", webhook.WebhookId)
+			i.Redirect(w, r, url)
+		}
+		SaveSession(w, r)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func GetWebhook(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		webhookId, err := strconv.ParseInt(vars["webhook_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse webhook id", slog.Any("error", err))
+			errs.Add("input", err)
+
+			Render(w, errs.Request(r), i, "Home/Webhook", nil)
+			return
+		}
+		props := inertia.Props{
+			"webhookId": webhookId,
+		}
+
+		webhook, err := database.GetWebhook(db, webhookId)
+		if err != nil {
+			slog.Error("Failed to get webhook", slog.Any("error", err))
+			errs.Add("webhook", err)
+
+			Render(w, errs.Request(r), i, "Home/Webhook", props)
+			return
+		}
+		props["webhook"] = webhook
+
+		if webhook.CronId != nil {
+			cron, err := database.GetCron(db, *webhook.CronId)
+			if err != nil {
+				slog.Error("Failed to get cron", slog.Any("error", err))
+				errs.Add("cron", err)
+			}
+			props["cron"] = cron
+		}
+
+		deliveries, err := database.GetWebhookDeliveries(db, webhookId, deliveryLogSize)
+		if err != nil {
+			slog.Error("Failed to get webhook deliveries", slog.Any("error", err))
+			errs.Add("deliveries", err)
+		}
+		props["deliveries"] = deliveries
+
+		Render(w, errs.Request(r), i, "Home/Webhook", props)
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
+
+func DeleteWebhook(i *inertia.Inertia, db *database.Database) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		errs := NewErrors(r)
+		vars := mux.Vars(r)
+
+		webhookId, err := strconv.ParseInt(vars["webhook_id"], 10, 64)
+		if err != nil {
+			slog.Error("Failed to parse webhook id", slog.Any("error", err))
+			errs.Add("input", err)
+
+			Render(w, errs.Request(r), i, "Home/Webhook", nil)
+			return
+		}
+
+		err = database.DeleteWebhook(db, webhookId)
+		if err != nil {
+			slog.Error("Failed to delete webhook", slog.Any("error", err))
+			errs.Add("deletion", err)
+		}
+
+		if errs.HasErrors() {
+			errs.Save(w, r)
+			i.Back(w, r)
+		} else {
+			i.Redirect(w, r, "/webhooks")
+		}
+	}
+
+	return i.Middleware(http.HandlerFunc(fn))
+}
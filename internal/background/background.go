@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"d34d.one/grognon/internal/database"
+	"d34d.one/grognon/internal/webhooks"
 )
 
 func backgroundTask(ctx context.Context, duration time.Duration, eager bool, task func()) {
@@ -35,6 +36,42 @@ func SetupCronJobs(ctx context.Context, db *database.Database, cons database.Con
 	})
 }
 
+func SetupRetention(ctx context.Context, db *database.Database) {
+	backgroundTask(ctx, 10*time.Minute, true, func() {
+		err := database.ApplyRetention(db)
+		if err != nil {
+			slog.Error("Failed to apply retention", "error", err)
+		}
+	})
+}
+
+func SetupRunLog(ctx context.Context, db *database.Database) {
+	backgroundTask(ctx, time.Hour, true, func() {
+		err := database.PruneCronRuns(db)
+		if err != nil {
+			slog.Error("Failed to prune cron runs", "error", err)
+		}
+	})
+}
+
+func SetupRollups(ctx context.Context, db *database.Database) {
+	backgroundTask(ctx, 5*time.Minute, true, func() {
+		err := database.UpdateRollups(db)
+		if err != nil {
+			slog.Error("Failed to update rollups", "error", err)
+		}
+	})
+}
+
+func SetupPartitions(ctx context.Context, db *database.Database) {
+	backgroundTask(ctx, time.Hour, true, func() {
+		err := database.MaintainPartitions(db)
+		if err != nil {
+			slog.Error("Failed to maintain partitions", "error", err)
+		}
+	})
+}
+
 func SetupReflection(ctx context.Context, db *database.Database, cons database.Connections) {
 	backgroundTask(ctx, 30*time.Minute, true, func() {
 		err := database.ReflectAll(db, cons)
@@ -52,3 +89,28 @@ func SetupDBRefresh(ctx context.Context, db *database.Database, cons database.Co
 		}
 	})
 }
+
+func SetupWebhooks(ctx context.Context, db *database.Database) {
+	db.AddEventObserver(webhooks.Observe(db))
+	backgroundTask(ctx, 10*time.Second, true, func() {
+		err := webhooks.DeliverDue(ctx, db)
+		if err != nil {
+			slog.Error("Failed to deliver webhooks", "error", err)
+		}
+	})
+	backgroundTask(ctx, time.Hour, true, func() {
+		err := database.PruneWebhookDeliveries(db)
+		if err != nil {
+			slog.Error("Failed to prune webhook deliveries", "error", err)
+		}
+	})
+}
+
+func SetupNotificationLog(ctx context.Context, db *database.Database) {
+	backgroundTask(ctx, time.Hour, true, func() {
+		err := database.PruneNotifications(db)
+		if err != nil {
+			slog.Error("Failed to prune notifications", "error", err)
+		}
+	})
+}
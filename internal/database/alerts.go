@@ -0,0 +1,354 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Series not seen for this long are forgotten by absent_for rules
+const absentLookback = 7 * 24 * time.Hour
+
+func AddAlertRule(db *Database, input AlertRuleCreate) (*AlertRule, error) {
+	cron, err := GetCron(db, input.CronId)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := GetCronOutputs(db, cron.CronId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting cron outputs")
+	}
+	labels, metrics := splitOutputs(*cron, outputs)
+
+	if strings.TrimSpace(input.Name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if !slices.Contains(Conditions, input.Condition) {
+		return nil, fmt.Errorf("unknown condition %s", input.Condition)
+	}
+	if !slices.ContainsFunc(metrics, func(o CronOutput) bool { return o.Name == input.ColumnName }) {
+		return nil, fmt.Errorf("%s is not a numeric output of cron %s", input.ColumnName, cron.Name)
+	}
+	for label := range input.LabelFilter {
+		if !slices.Contains(labels, label) {
+			return nil, fmt.Errorf("%s is not a label of cron %s", label, cron.Name)
+		}
+	}
+	if input.Condition == ConditionAbsentFor && input.Threshold <= 0 {
+		return nil, fmt.Errorf("absent_for needs a positive number of seconds")
+	}
+	// Series drop out of the lookback before they could be reported absent for longer
+	if input.Condition == ConditionAbsentFor && time.Duration(input.Threshold+float64(input.ForSeconds))*time.Second >= absentLookback {
+		return nil, fmt.Errorf("absent_for and for must add up to less than %d days", int(absentLookback.Hours()/24))
+	}
+	if input.Condition == ConditionAnomaly && input.Threshold <= 0 {
+		return nil, fmt.Errorf("anomaly needs a positive score")
+	}
+	if input.ForSeconds < 0 {
+		return nil, fmt.Errorf("for must not be negative")
+	}
+	if input.LabelFilter == nil {
+		input.LabelFilter = LabelSet{}
+	}
+
+	var id int64
+	row := db.QueryRow(
+		"INSERT INTO alert_rules (cron_id, name, column_name, label_filter, condition, threshold, for_seconds) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING rule_id",
+		cron.CronId, input.Name, input.ColumnName, input.LabelFilter.Key(), input.Condition, input.Threshold, input.ForSeconds,
+	)
+	if err := row.Scan(&id); err != nil {
+		return nil, errors.Wrap(err, "Error adding alert rule")
+	}
+	return GetAlertRule(db, id)
+}
+
+func GetAlertRule(db *Database, id int64) (*AlertRule, error) {
+	var rule AlertRule
+	row := db.QueryRow("SELECT * FROM alert_rules WHERE rule_id = $1 AND deleted_at IS NULL", id)
+	if err := row.Bind(&rule); err != nil {
+		return nil, errors.Wrap(err, "Error binding alert rule")
+	}
+	if rule.RuleId == 0 {
+		return nil, fmt.Errorf("alert rule %d not found", id)
+	}
+	return &rule, nil
+}
+
+// GetAlertRules lists every rule, or the ones of a cron
+func GetAlertRules(db *Database, cronId *int64) ([]AlertRule, error) {
+	var rules []AlertRule
+	query := "SELECT * FROM alert_rules WHERE deleted_at IS NULL"
+	var args []interface{}
+	if cronId != nil {
+		query += " AND cron_id = $1"
+		args = append(args, *cronId)
+	}
+	query += " ORDER BY rule_id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting alert rules")
+	}
+	if err := rows.Bind(&rules); err != nil {
+		return nil, errors.Wrap(err, "Error binding alert rules")
+	}
+	return rules, nil
+}
+
+// DeleteAlertRule stops evaluating a rule, its alerts are resolved without notice
+func DeleteAlertRule(db *Database, id int64) error {
+	if _, err := db.Exec("UPDATE alert_rules SET deleted_at = NOW() WHERE rule_id = $1", id); err != nil {
+		return errors.Wrap(err, "Error deleting alert rule")
+	}
+	_, err := db.Exec("UPDATE alerts SET state = $1, resolved_at = NOW(), updated_at = NOW() WHERE rule_id = $2 AND state != $1", AlertResolved, id)
+	return err
+}
+
+// GetActiveAlerts lists the pending and firing alerts of every rule
+func GetActiveAlerts(db *Database) ([]Alert, error) {
+	var alerts []Alert
+	rows, err := db.Query("SELECT * FROM alerts WHERE state != $1 ORDER BY started_at DESC", AlertResolved)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting active alerts")
+	}
+	if err := rows.Bind(&alerts); err != nil {
+		return nil, errors.Wrap(err, "Error binding alerts")
+	}
+	return alerts, nil
+}
+
+// GetAlerts lists the latest alerts of a rule, resolved ones included
+func GetAlerts(db *Database, ruleId int64, limit int) ([]Alert, error) {
+	var alerts []Alert
+	rows, err := db.Query("SELECT * FROM alerts WHERE rule_id = $1 ORDER BY alert_id DESC LIMIT $2", ruleId, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting alerts")
+	}
+	if err := rows.Bind(&alerts); err != nil {
+		return nil, errors.Wrap(err, "Error binding alerts")
+	}
+	return alerts, nil
+}
+
+// seriesState is the outcome of a rule on one series
+type seriesState struct {
+	labels  LabelSet
+	value   *float64
+	matches bool
+}
+
+// evaluateAlertRules moves the alerts of a cron through their states, it runs
+// after every run of the cron whether it succeeded or not
+func evaluateAlertRules(db *Database, cron Cron, outputs []CronOutput, now time.Time) error {
+	rules, err := GetAlertRules(db, &cron.CronId)
+	if err != nil {
+		return err
+	}
+	labels, metrics := splitOutputs(cron, outputs)
+
+	for _, rule := range rules {
+		if !slices.ContainsFunc(metrics, func(o CronOutput) bool { return o.Name == rule.ColumnName }) {
+			slog.Warn("Alert rule column is not an output anymore", slog.Int64("id", rule.RuleId), slog.String("column", rule.ColumnName))
+			continue
+		}
+		series, err := ruleSeries(db, cron, labels, rule, now)
+		if err != nil {
+			slog.Error("Error evaluating alert rule", slog.Int64("id", rule.RuleId), slog.Any("error", err))
+			continue
+		}
+		if err := updateAlerts(db, cron, rule, series, now); err != nil {
+			slog.Error("Error updating alerts", slog.Int64("id", rule.RuleId), slog.Any("error", err))
+		}
+	}
+	return nil
+}
+
+// ruleSeries reads the series matching the label filter of a rule and checks its condition on each
+func ruleSeries(db *Database, cron Cron, labels []string, rule AlertRule, now time.Time) ([]seriesState, error) {
+	if rule.Condition == ConditionAnomaly {
+		return anomalyStates(db, cron, labels, rule)
+	}
+
+	where, args, err := labelFilterWhere(labels, rule.LabelFilter, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	table := "crons_data." + cron.Slug
+	var query string
+	switch rule.Condition {
+	case ConditionAbsentFor:
+		args = append(args, now.Add(-absentLookback))
+		where = append(where, fmt.Sprintf("timestamp > $%d", len(args)))
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(labelSelects(labels, "MAX(timestamp)", "NULL::DOUBLE PRECISION"), ", "), table, strings.Join(where, " AND "))
+		if len(labels) > 0 {
+			query += " GROUP BY " + strings.Join(labels, ", ")
+		}
+	case ConditionChangeRate:
+		// The last two runs holding the filtered series, not of the whole table
+		runs := fmt.Sprintf("SELECT DISTINCT timestamp FROM %s", table)
+		if len(where) > 0 {
+			runs += " WHERE " + strings.Join(where, " AND ")
+		}
+		where = append(where, fmt.Sprintf("timestamp IN (%s ORDER BY timestamp DESC LIMIT 2)", runs))
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY timestamp", strings.Join(labelSelects(labels, "timestamp", rule.ColumnName+"::DOUBLE PRECISION"), ", "), table, strings.Join(where, " AND "))
+	default:
+		where = append(where, fmt.Sprintf("timestamp = (SELECT MAX(timestamp) FROM %s)", table))
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(labelSelects(labels, "timestamp", rule.ColumnName+"::DOUBLE PRECISION"), ", "), table, strings.Join(where, " AND "))
+	}
+
+	series, err := readSeries(db, labels, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// A rule matching nothing at all is about a series that never showed up
+	if rule.Condition == ConditionAbsentFor && len(series) == 0 {
+		return []seriesState{{labels: rule.LabelFilter, matches: true}}, nil
+	}
+
+	states := make([]seriesState, 0, len(series))
+	for _, s := range series {
+		state := seriesState{labels: s.labels}
+		points := s.samples
+		last := s.last()
+
+		switch rule.Condition {
+		case ConditionAbove:
+			state.value = last.value
+			state.matches = last.value != nil && *last.value > rule.Threshold
+		case ConditionBelow:
+			state.value = last.value
+			state.matches = last.value != nil && *last.value < rule.Threshold
+		case ConditionChangeRate:
+			if len(points) < 2 || last.value == nil || points[0].value == nil {
+				break
+			}
+			minutes := last.time.Sub(points[0].time).Minutes()
+			if minutes <= 0 {
+				break
+			}
+			rate := (*last.value - *points[0].value) / minutes
+			state.value = &rate
+			state.matches = math.Abs(rate) > rule.Threshold
+		case ConditionAbsentFor:
+			absent := now.Sub(last.time).Seconds()
+			state.value = &absent
+			state.matches = absent > rule.Threshold
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// updateAlerts applies the outcome of a rule: matching series become pending then firing
+// once the for duration is over, the others are resolved
+func updateAlerts(db *Database, cron Cron, rule AlertRule, series []seriesState, now time.Time) error {
+	var active []Alert
+	rows, err := db.Query("SELECT * FROM alerts WHERE rule_id = $1 AND state != $2", rule.RuleId, AlertResolved)
+	if err != nil {
+		return errors.Wrap(err, "Error getting active alerts")
+	}
+	if err := rows.Bind(&active); err != nil {
+		return errors.Wrap(err, "Error binding alerts")
+	}
+	previous := map[string]Alert{}
+	for _, alert := range active {
+		previous[alert.Series.Key()] = alert
+	}
+
+	for _, s := range series {
+		if !s.matches {
+			continue
+		}
+		key := s.labels.Key()
+		alert, ok := previous[key]
+		delete(previous, key)
+
+		switch {
+		case !ok:
+			alert = Alert{RuleId: rule.RuleId, Series: s.labels, State: AlertPending, Value: s.value, StartedAt: now}
+			if rule.ForSeconds == 0 {
+				alert.State = AlertFiring
+				alert.FiredAt = &now
+			}
+			row := db.QueryRow(
+				"INSERT INTO alerts (rule_id, series, state, value, started_at, fired_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING alert_id",
+				rule.RuleId, key, alert.State, alert.Value, alert.StartedAt, alert.FiredAt,
+			)
+			if err := row.Scan(&alert.AlertId); err != nil {
+				return errors.Wrap(err, "Error adding alert")
+			}
+		case alert.State == AlertPending && now.Sub(alert.StartedAt) >= rule.For():
+			alert.State = AlertFiring
+			alert.FiredAt = &now
+			alert.Value = s.value
+			if _, err := db.Exec(
+				"UPDATE alerts SET state = $1, value = $2, fired_at = $3, updated_at = NOW() WHERE alert_id = $4",
+				alert.State, alert.Value, alert.FiredAt, alert.AlertId,
+			); err != nil {
+				return errors.Wrap(err, "Error firing alert")
+			}
+		default:
+			if _, err := db.Exec("UPDATE alerts SET value = $1, updated_at = NOW() WHERE alert_id = $2", s.value, alert.AlertId); err != nil {
+				return errors.Wrap(err, "Error updating alert")
+			}
+			continue
+		}
+
+		if alert.State == AlertFiring {
+			db.emit(alertEvent(EventAlertFiring, cron, rule, alert))
+		}
+	}
+
+	// Series no longer matching, or gone
+	for _, alert := range previous {
+		if alert.State == AlertPending {
+			if _, err := db.Exec("DELETE FROM alerts WHERE alert_id = $1", alert.AlertId); err != nil {
+				return errors.Wrap(err, "Error dropping pending alert")
+			}
+			continue
+		}
+
+		alert.State = AlertResolved
+		alert.ResolvedAt = &now
+		if _, err := db.Exec(
+			"UPDATE alerts SET state = $1, resolved_at = $2, updated_at = NOW() WHERE alert_id = $3",
+			alert.State, alert.ResolvedAt, alert.AlertId,
+		); err != nil {
+			return errors.Wrap(err, "Error resolving alert")
+		}
+		db.emit(alertEvent(EventAlertResolved, cron, rule, alert))
+	}
+	return nil
+}
+
+func alertEvent(eventType string, cron Cron, rule AlertRule, alert Alert) Event {
+	data := map[string]interface{}{
+		"alert_id":  alert.AlertId,
+		"rule_id":   rule.RuleId,
+		"rule":      rule.Name,
+		"column":    rule.ColumnName,
+		"condition": rule.Condition,
+		"threshold": rule.Threshold,
+		"labels":    alert.Series,
+		"value":     alert.Value,
+	}
+	// Keeps the payload valid JSON whatever the value
+	if alert.Value != nil && (math.IsNaN(*alert.Value) || math.IsInf(*alert.Value, 0)) {
+		data["value"] = nil
+	}
+	return Event{
+		Type:     eventType,
+		Time:     time.Now(),
+		CronId:   &cron.CronId,
+		CronName: cron.Name,
+		CronSlug: cron.Slug,
+		Data:     data,
+	}
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Annotations returned when a query sets no limit
+const defaultAnnotationLimit = 1000
+
+// validateAnnotation checks the input and resolves its scope to nullable ids
+func validateAnnotation(db *Database, input AnnotationInput) (*int64, *int64, error) {
+	if strings.TrimSpace(input.Text) == "" {
+		return nil, nil, fmt.Errorf("text is required")
+	}
+	if input.Time.IsZero() {
+		return nil, nil, fmt.Errorf("time is required")
+	}
+	if input.TimeEnd != nil && input.TimeEnd.Before(input.Time) {
+		return nil, nil, fmt.Errorf("the end of the annotation must be after its start")
+	}
+
+	var cronId, connectionId *int64
+	if input.CronId != 0 {
+		if _, err := GetCron(db, input.CronId); err != nil {
+			return nil, nil, err
+		}
+		cronId = &input.CronId
+	}
+	if input.ConnectionId != 0 {
+		if _, err := GetConnection(db, input.ConnectionId); err != nil {
+			return nil, nil, err
+		}
+		connectionId = &input.ConnectionId
+	}
+	return cronId, connectionId, nil
+}
+
+func AddAnnotation(db *Database, input AnnotationInput) (*Annotation, error) {
+	cronId, connectionId, err := validateAnnotation(db, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int64
+	row := db.QueryRow(
+		"INSERT INTO annotations (cron_id, connection_id, time, time_end, text, tags) VALUES ($1, $2, $3, $4, $5, $6) RETURNING annotation_id",
+		cronId, connectionId, input.Time, input.TimeEnd, input.Text, []string(input.Tags.Clean()),
+	)
+	if err := row.Scan(&id); err != nil {
+		return nil, errors.Wrap(err, "Error adding annotation")
+	}
+	return GetAnnotation(db, id)
+}
+
+func UpdateAnnotation(db *Database, id int64, input AnnotationInput) (*Annotation, error) {
+	if _, err := GetAnnotation(db, id); err != nil {
+		return nil, err
+	}
+	cronId, connectionId, err := validateAnnotation(db, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(
+		"UPDATE annotations SET cron_id = $1, connection_id = $2, time = $3, time_end = $4, text = $5, tags = $6 WHERE annotation_id = $7",
+		cronId, connectionId, input.Time, input.TimeEnd, input.Text, []string(input.Tags.Clean()), id,
+	); err != nil {
+		return nil, errors.Wrap(err, "Error updating annotation")
+	}
+	return GetAnnotation(db, id)
+}
+
+var ErrAnnotationNotFound = fmt.Errorf("annotation not found")
+
+func GetAnnotation(db *Database, id int64) (*Annotation, error) {
+	var annotation Annotation
+	row := db.QueryRow("SELECT * FROM annotations WHERE annotation_id = $1", id)
+	if err := row.Bind(&annotation); err != nil {
+		return nil, errors.Wrap(err, "Error binding annotation")
+	}
+	if annotation.AnnotationId == 0 {
+		return nil, fmt.Errorf("annotation %d: %w", id, ErrAnnotationNotFound)
+	}
+	return &annotation, nil
+}
+
+func DeleteAnnotation(db *Database, id int64) error {
+	if _, err := db.Exec("DELETE FROM annotations WHERE annotation_id = $1", id); err != nil {
+		return errors.Wrap(err, "Error deleting annotation")
+	}
+	return nil
+}
+
+// GetAnnotations lists the annotations overlapping the range of the query, oldest first
+func GetAnnotations(db *Database, q AnnotationQuery) ([]Annotation, error) {
+	args := []interface{}{q.To, q.From}
+	where := []string{"time <= $1", "COALESCE(time_end, time) >= $2"}
+
+	if q.CronId != nil {
+		cron, err := GetCron(db, *q.CronId)
+		if err != nil {
+			return nil, err
+		}
+		connectionIds, err := GetCronConnections(db, *cron)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error getting cron connections")
+		}
+		args = append(args, cron.CronId, connectionIds)
+		where = append(where, fmt.Sprintf(
+			"(cron_id = $%d OR (cron_id IS NULL AND (connection_id IS NULL OR connection_id = ANY($%d))))",
+			len(args)-1, len(args),
+		))
+	}
+	if tags := q.Tags.Clean(); len(tags) > 0 {
+		args = append(args, []string(tags))
+		where = append(where, fmt.Sprintf("tags @> $%d", len(args)))
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultAnnotationLimit
+	}
+	query := fmt.Sprintf("SELECT * FROM annotations WHERE %s ORDER BY time LIMIT %d", strings.Join(where, " AND "), limit)
+
+	var annotations []Annotation
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting annotations")
+	}
+	if err := rows.Bind(&annotations); err != nil {
+		return nil, errors.Wrap(err, "Error binding annotations")
+	}
+	return annotations, nil
+}
@@ -0,0 +1,384 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAnomalyThreshold = 3
+	defaultZScoreWindow     = 60
+	defaultSeasonalWindow   = 4
+	// Samples needed in the baseline before scoring
+	minBaseline = 3
+	// How far back the rolling window goes for crons with an unknown schedule
+	defaultAnomalyLookback = 30 * 24 * time.Hour
+	// Least spread of a baseline, relative to its mean and at least minStddev, so that
+	// flat baselines are scored too and a change from a constant series scores high
+	minStddevRatio = 0.01
+	minStddev      = 1e-6
+)
+
+// Used by anomaly alert rules on columns without a detector
+var defaultDetector = AnomalyDetector{
+	Method:     DetectorZScore,
+	Threshold:  defaultAnomalyThreshold,
+	WindowSize: defaultZScoreWindow,
+}
+
+func AddAnomalyDetector(db *Database, input AnomalyDetectorCreate) (*AnomalyDetector, error) {
+	cron, err := GetCron(db, input.CronId)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := GetCronOutputs(db, cron.CronId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting cron outputs")
+	}
+	_, metrics := splitOutputs(*cron, outputs)
+	if !slices.ContainsFunc(metrics, func(o CronOutput) bool { return o.Name == input.ColumnName }) {
+		return nil, fmt.Errorf("%s is not a numeric output of cron %s", input.ColumnName, cron.Name)
+	}
+
+	if input.Threshold == 0 {
+		input.Threshold = defaultAnomalyThreshold
+	}
+	if input.Threshold < 0 {
+		return nil, fmt.Errorf("threshold must be positive")
+	}
+
+	var season *string
+	switch input.Method {
+	case DetectorZScore:
+		if input.WindowSize == 0 {
+			input.WindowSize = defaultZScoreWindow
+		}
+	case DetectorSeasonal:
+		if input.WindowSize == 0 {
+			input.WindowSize = defaultSeasonalWindow
+		}
+		period, ok := Seasons[input.Season]
+		if !ok {
+			return nil, fmt.Errorf("unknown season %s", input.Season)
+		}
+		if interval := cron.Interval(); interval == 0 || interval >= period {
+			return nil, fmt.Errorf("cron %s does not run more than once a %s", cron.Name, input.Season)
+		}
+		season = &input.Season
+	default:
+		return nil, fmt.Errorf("unknown detection method %s", input.Method)
+	}
+	if input.WindowSize < minBaseline {
+		return nil, fmt.Errorf("window must be at least %d", minBaseline)
+	}
+
+	var id int64
+	row := db.QueryRow(
+		"INSERT INTO anomaly_detectors (cron_id, column_name, method, threshold, window_size, season) VALUES ($1, $2, $3, $4, $5, $6) RETURNING detector_id",
+		cron.CronId, input.ColumnName, input.Method, input.Threshold, input.WindowSize, season,
+	)
+	if err := row.Scan(&id); err != nil {
+		return nil, errors.Wrap(err, "Error adding anomaly detector, the column may already have one")
+	}
+	return GetAnomalyDetector(db, id)
+}
+
+func GetAnomalyDetector(db *Database, id int64) (*AnomalyDetector, error) {
+	var detector AnomalyDetector
+	row := db.QueryRow("SELECT * FROM anomaly_detectors WHERE detector_id = $1 AND deleted_at IS NULL", id)
+	if err := row.Bind(&detector); err != nil {
+		return nil, errors.Wrap(err, "Error binding anomaly detector")
+	}
+	if detector.DetectorId == 0 {
+		return nil, fmt.Errorf("anomaly detector %d not found", id)
+	}
+	return &detector, nil
+}
+
+func GetAnomalyDetectors(db *Database, cronId int64) ([]AnomalyDetector, error) {
+	var detectors []AnomalyDetector
+	rows, err := db.Query("SELECT * FROM anomaly_detectors WHERE cron_id = $1 AND deleted_at IS NULL ORDER BY detector_id", cronId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting anomaly detectors")
+	}
+	if err := rows.Bind(&detectors); err != nil {
+		return nil, errors.Wrap(err, "Error binding anomaly detectors")
+	}
+	return detectors, nil
+}
+
+func DeleteAnomalyDetector(db *Database, id int64) error {
+	if _, err := db.Exec("UPDATE anomaly_detectors SET deleted_at = NOW() WHERE detector_id = $1", id); err != nil {
+		return errors.Wrap(err, "Error deleting anomaly detector")
+	}
+	return nil
+}
+
+// anomalyScore compares the latest sample of a series with its baseline
+type anomalyScore struct {
+	labels LabelSet
+	time   time.Time
+	value  float64
+	mean   float64
+	stddev float64
+	score  float64
+}
+
+// scoreSeries scores the latest sample of every series of a column against the previous
+// samples, or against the samples at the same time of the previous seasons. Unless run is
+// zero, nothing is scored when the latest samples are not from that run.
+func scoreSeries(db *Database, cron Cron, labels []string, detector AnomalyDetector, filter LabelSet, run time.Time) ([]anomalyScore, error) {
+	table := "crons_data." + cron.Slug
+	var latest sql.NullTime
+	if err := db.QueryRow(fmt.Sprintf("SELECT MAX(timestamp) FROM %s", table)).Scan(&latest); err != nil {
+		return nil, errors.Wrap(err, "Error getting latest sample")
+	}
+	if !latest.Valid {
+		return nil, nil
+	}
+	if !run.IsZero() && !latest.Time.Equal(run) {
+		return nil, nil
+	}
+
+	where, args, err := labelFilterWhere(labels, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	selects := strings.Join(labelSelects(labels, "timestamp", detector.ColumnName+"::DOUBLE PRECISION"), ", ")
+	interval := cron.Interval()
+
+	var query string
+	switch detector.Method {
+	case DetectorSeasonal:
+		if detector.Season == nil {
+			return nil, fmt.Errorf("seasonal detector without a season")
+		}
+		period, ok := Seasons[*detector.Season]
+		if !ok {
+			return nil, fmt.Errorf("unknown season %s", *detector.Season)
+		}
+		// Runs drift a little from one season to the next
+		tolerance := max(interval/2, time.Minute)
+
+		args = append(args, latest.Time)
+		times := []string{fmt.Sprintf("timestamp = $%d", len(args))}
+		for k := int64(1); k <= detector.WindowSize; k++ {
+			at := latest.Time.Add(-time.Duration(k) * period)
+			args = append(args, at.Add(-tolerance), at.Add(tolerance))
+			times = append(times, fmt.Sprintf("timestamp BETWEEN $%d AND $%d", len(args)-1, len(args)))
+		}
+		where = append(where, "("+strings.Join(times, " OR ")+")")
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY timestamp", selects, table, strings.Join(where, " AND "))
+	default:
+		lookback := defaultAnomalyLookback
+		if interval > 0 {
+			lookback = 3 * time.Duration(detector.WindowSize+1) * interval
+		}
+		args = append(args, latest.Time.Add(-lookback), latest.Time)
+		where = append(where, fmt.Sprintf("timestamp > $%d AND timestamp <= $%d", len(args)-1, len(args)))
+
+		partition := ""
+		if len(labels) > 0 {
+			partition = "PARTITION BY " + strings.Join(labels, ", ")
+		}
+		query = fmt.Sprintf(
+			"SELECT %s FROM (SELECT *, ROW_NUMBER() OVER (%s ORDER BY timestamp DESC) AS sample_rank FROM %s WHERE %s) ranked WHERE sample_rank <= %d ORDER BY timestamp",
+			selects, partition, table, strings.Join(where, " AND "), detector.WindowSize+1,
+		)
+	}
+
+	series, err := readSeries(db, labels, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var scores []anomalyScore
+	for _, s := range series {
+		last := s.last()
+		// Series missing from the latest run are not scored
+		if !last.time.Equal(latest.Time) || last.value == nil {
+			continue
+		}
+		var baseline []float64
+		for _, sample := range s.samples[:len(s.samples)-1] {
+			if sample.value != nil {
+				baseline = append(baseline, *sample.value)
+			}
+		}
+		if len(baseline) < minBaseline {
+			continue
+		}
+
+		mean, stddev := meanStddev(baseline)
+		stddev = max(stddev, math.Abs(mean)*minStddevRatio, minStddev)
+		scores = append(scores, anomalyScore{
+			labels: s.labels,
+			time:   last.time,
+			value:  *last.value,
+			mean:   mean,
+			stddev: stddev,
+			score:  (*last.value - mean) / stddev,
+		})
+	}
+	return scores, nil
+}
+
+// meanStddev returns the mean and the sample standard deviation
+func meanStddev(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)-1))
+}
+
+// detectorFor returns the detector of a column, or the default one
+func detectorFor(db *Database, cronId int64, column string) (AnomalyDetector, error) {
+	var detector AnomalyDetector
+	row := db.QueryRow("SELECT * FROM anomaly_detectors WHERE cron_id = $1 AND column_name = $2 AND deleted_at IS NULL", cronId, column)
+	if err := row.Bind(&detector); err != nil {
+		return detector, errors.Wrap(err, "Error binding anomaly detector")
+	}
+	if detector.DetectorId == 0 {
+		detector = defaultDetector
+		detector.CronId = cronId
+		detector.ColumnName = column
+	}
+	return detector, nil
+}
+
+// anomalyStates checks an anomaly alert rule, the value is the score of the latest sample
+func anomalyStates(db *Database, cron Cron, labels []string, rule AlertRule) ([]seriesState, error) {
+	detector, err := detectorFor(db, cron.CronId, rule.ColumnName)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := scoreSeries(db, cron, labels, detector, rule.LabelFilter, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]seriesState, 0, len(scores))
+	for _, s := range scores {
+		score := s.score
+		states = append(states, seriesState{
+			labels:  s.labels,
+			value:   &score,
+			matches: math.Abs(score) > rule.Threshold,
+		})
+	}
+	return states, nil
+}
+
+// evaluateAnomalyDetectors annotates the anomalous samples of a run of a cron, a run
+// that saved nothing has nothing to score
+func evaluateAnomalyDetectors(db *Database, cron Cron, outputs []CronOutput, now time.Time) error {
+	detectors, err := GetAnomalyDetectors(db, cron.CronId)
+	if err != nil {
+		return err
+	}
+	labels, metrics := splitOutputs(cron, outputs)
+
+	for _, detector := range detectors {
+		if !slices.ContainsFunc(metrics, func(o CronOutput) bool { return o.Name == detector.ColumnName }) {
+			slog.Warn("Anomaly detector column is not an output anymore", slog.Int64("id", detector.DetectorId), slog.String("column", detector.ColumnName))
+			continue
+		}
+		scores, err := scoreSeries(db, cron, labels, detector, nil, now)
+		if err != nil {
+			slog.Error("Error scoring series", slog.Int64("id", detector.DetectorId), slog.Any("error", err))
+			continue
+		}
+
+		for _, s := range scores {
+			if math.Abs(s.score) <= detector.Threshold {
+				continue
+			}
+
+			tags := Tags{"anomaly", detector.ColumnName}
+			for _, label := range labels {
+				if value, ok := s.labels[label]; ok {
+					tags = append(tags, label+"="+value)
+				}
+			}
+			annotation := AnnotationInput{
+				CronId: cron.CronId,
+				Time:   s.time,
+				Text:   fmt.Sprintf("Anomalous %s: %g, expected %.4g ± %.4g (score %.2f)", detector.ColumnName, s.value, s.mean, s.stddev, s.score),
+				Tags:   tags,
+			}
+			added, err := addAnomalyAnnotation(db, annotation)
+			if err != nil {
+				slog.Error("Error saving anomaly", slog.Int64("id", detector.DetectorId), slog.Any("error", err))
+				continue
+			}
+			// Already reported
+			if !added {
+				continue
+			}
+
+			db.emit(Event{
+				Type:     EventAnomaly,
+				Time:     time.Now(),
+				CronId:   &cron.CronId,
+				CronName: cron.Name,
+				CronSlug: cron.Slug,
+				Data: map[string]interface{}{
+					"detector_id": detector.DetectorId,
+					"column":      detector.ColumnName,
+					"labels":      s.labels,
+					"value":       s.value,
+					"expected":    s.mean,
+					"stddev":      s.stddev,
+					"score":       s.score,
+				},
+			})
+		}
+	}
+	return nil
+}
+
+// addAnomalyAnnotation saves an anomaly once, false when it was already annotated
+func addAnomalyAnnotation(db *Database, input AnnotationInput) (bool, error) {
+	res, err := db.Exec(
+		"INSERT INTO annotations (cron_id, time, text, tags) VALUES ($1, $2, $3, $4) ON CONFLICT (cron_id, time, tags) WHERE 'anomaly' = ANY(tags) DO NOTHING",
+		input.CronId, input.Time, input.Text, []string(input.Tags.Clean()),
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "Error adding anomaly annotation")
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "Error adding anomaly annotation")
+	}
+	return added > 0, nil
+}
+
+// GetAnomalies lists the latest anomalies annotated on a cron
+func GetAnomalies(db *Database, cronId int64, limit int) ([]Annotation, error) {
+	var annotations []Annotation
+	rows, err := db.Query(
+		"SELECT * FROM annotations WHERE cron_id = $1 AND 'anomaly' = ANY(tags) ORDER BY time DESC LIMIT $2",
+		cronId, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting anomalies")
+	}
+	if err := rows.Bind(&annotations); err != nil {
+		return nil, errors.Wrap(err, "Error binding anomalies")
+	}
+	return annotations, nil
+}
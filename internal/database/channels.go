@@ -0,0 +1,214 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const notificationLogRetention = "30 days"
+
+func AddNotificationChannel(db *Database, input NotificationChannelCreate) (*NotificationChannel, error) {
+	if strings.TrimSpace(input.Name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	switch input.Kind {
+	case ChannelEmail:
+		if _, err := mail.ParseAddressList(input.Target); err != nil {
+			return nil, fmt.Errorf("invalid recipients: %w", err)
+		}
+	case ChannelSlack:
+		u, err := url.Parse(input.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook url %s", input.Target)
+		}
+	default:
+		return nil, fmt.Errorf("unknown channel kind %s", input.Kind)
+	}
+	events := input.Events.Clean()
+	for _, event := range events {
+		if !slices.Contains(EventTypes, event) {
+			return nil, fmt.Errorf("unknown event %s", event)
+		}
+	}
+	if _, err := template.New("message").Parse(input.Template); err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	if input.ThrottleSeconds < 0 {
+		return nil, fmt.Errorf("throttle must not be negative")
+	}
+
+	var cronId *int64
+	if input.CronId != 0 {
+		if _, err := GetCron(db, input.CronId); err != nil {
+			return nil, err
+		}
+		cronId = &input.CronId
+	}
+	var connectionTag *string
+	if tag := strings.TrimSpace(input.ConnectionTag); tag != "" {
+		connectionTag = &tag
+	}
+
+	var id int64
+	row := db.QueryRow(
+		"INSERT INTO notification_channels (name, kind, target, events, cron_id, connection_tag, template, throttle_seconds) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING channel_id",
+		input.Name, input.Kind, input.Target, []string(events), cronId, connectionTag, input.Template, input.ThrottleSeconds,
+	)
+	if err := row.Scan(&id); err != nil {
+		return nil, errors.Wrap(err, "Error adding notification channel")
+	}
+	return GetNotificationChannel(db, id)
+}
+
+func GetNotificationChannel(db *Database, id int64) (*NotificationChannel, error) {
+	var channel NotificationChannel
+	row := db.QueryRow("SELECT * FROM notification_channels WHERE channel_id = $1 AND deleted_at IS NULL", id)
+	if err := row.Bind(&channel); err != nil {
+		return nil, errors.Wrap(err, "Error binding notification channel")
+	}
+	if channel.ChannelId == 0 {
+		return nil, fmt.Errorf("notification channel %d not found", id)
+	}
+	return &channel, nil
+}
+
+func GetNotificationChannels(db *Database) ([]NotificationChannel, error) {
+	var channels []NotificationChannel
+	rows, err := db.Query("SELECT * FROM notification_channels WHERE deleted_at IS NULL ORDER BY channel_id")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting notification channels")
+	}
+	if err := rows.Bind(&channels); err != nil {
+		return nil, errors.Wrap(err, "Error binding notification channels")
+	}
+	return channels, nil
+}
+
+func DeleteNotificationChannel(db *Database, id int64) error {
+	if _, err := db.Exec("UPDATE notification_channels SET deleted_at = NOW() WHERE channel_id = $1", id); err != nil {
+		return errors.Wrap(err, "Error deleting notification channel")
+	}
+	return nil
+}
+
+// eventConnectionTags gathers the tags routing an event: the ones of its connection,
+// or of the connection of its cron, plus the tag a fan-out cron selects
+func eventConnectionTags(db *Database, event Event) (Tags, error) {
+	var tags Tags
+	connectionId := event.ConnectionId
+	if event.CronId != nil {
+		cron, err := GetCron(db, *event.CronId)
+		if err != nil {
+			return nil, err
+		}
+		if cron.ConnectionTag != nil {
+			tags = append(tags, *cron.ConnectionTag)
+		}
+		if connectionId == nil {
+			connectionId = &cron.ConnectionId
+		}
+	}
+	if connectionId != nil {
+		con, err := GetConnection(db, *connectionId)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, con.Tags...)
+	}
+	return tags, nil
+}
+
+// GetChannelsForEvent lists the channels an event is routed to
+func GetChannelsForEvent(db *Database, event Event) ([]NotificationChannel, error) {
+	channels, err := GetNotificationChannels(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags Tags
+	var routed []NotificationChannel
+	for i, channel := range channels {
+		if !channel.Subscribed(event.Type) {
+			continue
+		}
+		if channel.CronId != nil && (event.CronId == nil || *event.CronId != *channel.CronId) {
+			continue
+		}
+		if channel.ConnectionTag != nil {
+			if tags == nil {
+				if tags, err = eventConnectionTags(db, event); err != nil {
+					return nil, errors.Wrap(err, "Error getting event connection tags")
+				}
+			}
+			if !tags.Has(*channel.ConnectionTag) {
+				continue
+			}
+		}
+		routed = append(routed, channels[i])
+	}
+	return routed, nil
+}
+
+// IsThrottled tells whether the same notification was sent on the channel
+// less than its throttle ago
+func IsThrottled(db *Database, channel NotificationChannel, throttleKey string) (bool, error) {
+	if channel.ThrottleSeconds == 0 {
+		return false, nil
+	}
+	var throttled bool
+	row := db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM notifications WHERE channel_id = $1 AND throttle_key = $2 AND status = $3 AND created_at > NOW() - make_interval(secs => $4::INTEGER))",
+		channel.ChannelId, throttleKey, NotificationSent, channel.ThrottleSeconds,
+	)
+	if err := row.Scan(&throttled); err != nil {
+		return false, errors.Wrap(err, "Error checking notification throttle")
+	}
+	return throttled, nil
+}
+
+func SaveNotification(db *Database, notification Notification) error {
+	_, err := db.Exec(
+		"INSERT INTO notifications (channel_id, event, throttle_key, status, message, error) VALUES ($1, $2, $3, $4, $5, $6)",
+		notification.ChannelId, notification.Event, notification.ThrottleKey, notification.Status, notification.Message, notification.Error,
+	)
+	if err != nil {
+		return errors.Wrap(err, "Error saving notification")
+	}
+	return nil
+}
+
+// PruneNotifications drops the old entries of the notification log, the ones still
+// throttling their channel are kept
+func PruneNotifications(db *Database) error {
+	res, err := db.Exec(fmt.Sprintf(
+		"DELETE FROM notifications n WHERE created_at < NOW() - INTERVAL '%s' AND NOT EXISTS (SELECT 1 FROM notification_channels c WHERE c.channel_id = n.channel_id AND n.created_at > NOW() - make_interval(secs => c.throttle_seconds))",
+		notificationLogRetention,
+	))
+	if err != nil {
+		return errors.Wrap(err, "Error pruning notifications")
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		slog.Info("Pruned notifications", slog.Int64("count", n))
+	}
+	return nil
+}
+
+// GetNotifications lists the latest notifications of a channel
+func GetNotifications(db *Database, channelId int64, limit int) ([]Notification, error) {
+	var notifications []Notification
+	rows, err := db.Query("SELECT * FROM notifications WHERE channel_id = $1 ORDER BY notification_id DESC LIMIT $2", channelId, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting notifications")
+	}
+	if err := rows.Bind(&notifications); err != nil {
+		return nil, errors.Wrap(err, "Error binding notifications")
+	}
+	return notifications, nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
@@ -12,6 +13,15 @@ import (
 
 type Connections map[int64]*sqle.DB
 
+// sqliteDsn opens the database read-only, crons must never be able to write to a source
+func sqliteDsn(url string) string {
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return "file:" + url + sep + "mode=ro&_query_only=true"
+}
+
 func pushToConnections(db *Database, con Connection, connections Connections) error {
 	var con_db *sqle.DB
 	var sqldb *sql.DB
@@ -19,22 +29,25 @@ func pushToConnections(db *Database, con Connection, connections Connections) er
 
 	switch con.DbType {
 	case "sqlite":
-		sqldb, err = sql.Open("sqlite3", "file:"+con.ConnectionUrl)
+		sqldb, err = sql.Open("sqlite3", sqliteDsn(con.ConnectionUrl))
 		if err == nil {
 			con_db = sqle.Open(sqldb)
 			err = con_db.Ping()
 		}
 
+		// A connection that can't be opened was given a wrong url
+		if err != nil {
+			err = invalidInput(err)
+		}
 		if err == nil {
 			_, err = db.Exec("UPDATE connections SET connected = true, last_error = NULL, last_connected_at = NOW() WHERE connection_id = $1", con.ConnectionId)
 		} else {
-			_, saveErr := db.Exec("UPDATE connections SET connected = false, last_error = $1 WHERE connection_id = $2", err.Error(), con.ConnectionId)
-			if saveErr != nil {
+			if saveErr := markConnectionDown(db, con.ConnectionId, err); saveErr != nil {
 				err = errors.Wrap(saveErr, "failed to save connection error")
 			}
 		}
 	default:
-		err = fmt.Errorf("unknown database type %s", con.DbType)
+		err = invalidInput(fmt.Errorf("unknown database type %s", con.DbType))
 	}
 	if err != nil {
 		return errors.Wrap(err, "Error during the connection process")
@@ -52,12 +65,22 @@ func GetConnection(db *Database, id int64) (*Connection, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "Error getting connection")
 	}
+	if con.ConnectionId == 0 {
+		return nil, fmt.Errorf("connection %d not found", id)
+	}
 	return &con, nil
 }
 
 func AddConnection(db *Database, connections Connections, input ConnectionCreate) (*Connection, error) {
-	row := db.QueryRow("INSERT INTO connections (db_type, connection_url) VALUES ($1, $2) RETURNING connection_id",
-		input.DbType, input.ConnectionUrl)
+	if input.DbType != "sqlite" {
+		return nil, invalidInput(fmt.Errorf("unknown database type %s", input.DbType))
+	}
+	if strings.TrimSpace(input.ConnectionUrl) == "" {
+		return nil, invalidInput(fmt.Errorf("connection url is required"))
+	}
+
+	row := db.QueryRow("INSERT INTO connections (db_type, connection_url, name, description, tags) VALUES ($1, $2, $3, $4, $5) RETURNING connection_id",
+		input.DbType, input.ConnectionUrl, input.Name, input.Description, []string(input.Tags.Clean()))
 
 	var id int64
 	err := row.Scan(&id)
@@ -83,6 +106,11 @@ func DeleteConnection(db *Database, connections Connections, id int64) error {
 	if err != nil {
 		return errors.Wrap(err, "Error deleting crons for connection")
 	}
+	// Stop fan-out crons from targeting it
+	_, err = db.Exec("DELETE FROM cron_connections WHERE connection_id = $1", id)
+	if err != nil {
+		return errors.Wrap(err, "Error deleting cron connections")
+	}
 	// Delete connection
 	_, err = db.Exec("UPDATE connections SET connected = false, last_error = 'Connection removed', deleted_at = NOW() WHERE connection_id = $1", id)
 	if err != nil {
@@ -103,9 +131,18 @@ func DeleteConnection(db *Database, connections Connections, id int64) error {
 	return nil
 }
 
-func GetConnections(db *Database) ([]Connection, error) {
+func GetConnections(db *Database, tag *string) ([]Connection, error) {
 	var connection_list []Connection
-	rows, err := db.Query("SELECT * FROM connections WHERE deleted_at IS NULL")
+	query := "SELECT * FROM connections WHERE deleted_at IS NULL"
+	var args []interface{}
+
+	if tag != nil {
+		query += " AND $1 = ANY(tags)"
+		args = append(args, *tag)
+	}
+	query += " ORDER BY connection_id"
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -115,11 +152,33 @@ func GetConnections(db *Database) ([]Connection, error) {
 	return connection_list, nil
 }
 
+func GetTags(db *Database) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT unnest(tags) AS tag FROM connections WHERE deleted_at IS NULL ORDER BY tag")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting tags")
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("Error closing rows", slog.Any("error", err))
+		}
+	}()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, errors.Wrap(err, "Error scanning tag")
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
 func SetupConnections(db *Database) (Connections, error) {
 	slog.Info("Setting up connections...")
 	connections := make(Connections)
 
-	connection_list, err := GetConnections(db)
+	connection_list, err := GetConnections(db, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error getting connections")
 	}
@@ -136,7 +195,7 @@ func SetupConnections(db *Database) (Connections, error) {
 
 func RefreshConnections(db *Database, connections Connections) error {
 	slog.Info("Refreshing connections...")
-	connection_list, err := GetConnections(db)
+	connection_list, err := GetConnections(db, nil)
 	if err != nil {
 		return errors.Wrap(err, "Error getting connections")
 	}
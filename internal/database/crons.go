@@ -4,18 +4,33 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/pkg/errors"
 	"github.com/yaitoo/sqle"
 )
 
 type Object map[string]interface{}
 
+const (
+	// Rows read to infer the outputs of a cron
+	reflectRowLimit = 1000
+	// Rows sent to Postgres per COPY
+	copyBatchSize = 1000
+)
+
 func executeCron(con *sqle.DB, cron Cron) ([]Object, []string, error) {
+	return queryCron(context.Background(), con, cron.Command, reflectRowLimit)
+}
+
+// queryCron runs a cron command and reads at most limit rows, all of them if limit is 0
+func queryCron(ctx context.Context, con *sqle.DB, command string, limit int, args ...interface{}) ([]Object, []string, error) {
 	var output []Object
 
-	rows, err := con.Query(cron.Command)
+	rows, err := con.QueryContext(ctx, command, args...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -32,6 +47,9 @@ func executeCron(con *sqle.DB, cron Cron) ([]Object, []string, error) {
 
 	scanSlots := make([]interface{}, len(cols))
 	for rows.Next() {
+		if limit > 0 && len(output) >= limit {
+			break
+		}
 		object := make(Object, len(cols))
 		// Fill scanSlots with pointers to the values in object
 		for i := range cols {
@@ -48,44 +66,56 @@ func executeCron(con *sqle.DB, cron Cron) ([]Object, []string, error) {
 		output = append(output, object)
 	}
 
-	return output, cols, nil
+	return output, cols, rows.Err()
+}
+
+// inferOutput finds the type of a column from the values it holds
+func inferOutput(cronId int64, col string, objects []Object) (CronOutput, error) {
+	output := CronOutput{cronId, col, "NULL"}
+	for _, object := range objects {
+		colValue := object[col]
+		if colValue == nil {
+			return output, fmt.Errorf("column %s is null", col)
+		}
+		var valueType string
+		switch colValue.(type) {
+		case string:
+			valueType = "TEXT"
+		case int, int64:
+			valueType = "INTEGER"
+		case float64:
+			valueType = "REAL"
+		default:
+			return output, fmt.Errorf("unknown type %T", colValue)
+		}
+		if output.Type == "NULL" {
+			output.Type = valueType
+		} else if output.Type != valueType {
+			return output, fmt.Errorf("column %s has mixed types", col)
+		}
+	}
+	return output, nil
 }
 
 func reflectCron(con *sqle.DB, cron Cron) ([]CronOutput, error) {
 	var outputs []CronOutput
 
+	// The command is the user's, its failures are invalid inputs
 	objects, cols, err := executeCron(con, cron)
 	if err != nil {
-		return nil, err
+		return nil, invalidInput(err)
 	}
 	if len(objects) == 0 {
-		return nil, fmt.Errorf("no rows returned")
+		return nil, invalidInput(fmt.Errorf("no rows returned"))
 	}
 
+	if err := validateOutputNames(cron.FanOut, cols); err != nil {
+		return nil, err
+	}
 	for _, col := range cols {
-		output := CronOutput{cron.CronId, col, "NULL"}
-		for _, object := range objects {
-			colValue := object[col]
-			if colValue == nil {
-				return nil, fmt.Errorf("column %s is null", col)
-			}
-			var valueType string
-			switch colValue.(type) {
-			case string:
-				valueType = "TEXT"
-			case int:
-			case int64:
-				valueType = "INTEGER"
-			case float64:
-				valueType = "REAL"
-			default:
-				return nil, fmt.Errorf("unknown type %T", colValue)
-			}
-			if output.Type == "NULL" {
-				output.Type = valueType
-			} else if output.Type != valueType {
-				return nil, fmt.Errorf("column %s has mixed types", col)
-			}
+		output, err := inferOutput(cron.CronId, col, objects)
+		if err != nil {
+			return nil, invalidInput(err)
 		}
 		outputs = append(outputs, output)
 	}
@@ -101,6 +131,9 @@ func createCronTable(db *Database, con *sqle.DB, cron Cron) ([]CronOutput, error
 
 	tableQuery := fmt.Sprintf(`CREATE TABLE crons_data.%s (
 		timestamp TIMESTAMPTZ NOT NULL`, cron.Slug)
+	if cron.FanOut {
+		tableQuery += ",connection_id INTEGER NOT NULL"
+	}
 	indexQuery := fmt.Sprintf(
 		"CREATE INDEX cron_%d_timestamp ON crons_data.%s(timestamp);",
 		cron.CronId,
@@ -110,40 +143,131 @@ func createCronTable(db *Database, con *sqle.DB, cron Cron) ([]CronOutput, error
 	for _, output := range outputs {
 		tableQuery += fmt.Sprintf(",%s %s", output.Name, output.Type)
 	}
-	tableQuery += ");"
+	tableQuery += ")"
+	if cron.Partitioning != nil {
+		tableQuery += " PARTITION BY RANGE (timestamp)"
+	}
+	tableQuery += ";"
 	slog.Debug("Cron table creation", slog.String("tableQuery", tableQuery), slog.String("indexQuery", indexQuery))
 
 	if _, err := db.Exec(tableQuery); err != nil {
 		return nil, err
 	}
+	if cron.Hypertable {
+		// Hypertables come with their own timestamp index
+		if err := createHypertable(db, cron, outputs); err != nil {
+			return nil, err
+		}
+		return outputs, nil
+	}
 	if _, err := db.Exec(indexQuery); err != nil {
 		return nil, err
 	}
+	if cron.Partitioning != nil {
+		if err := ensurePartitions(db, cron); err != nil {
+			return nil, err
+		}
+	}
 
 	return outputs, nil
 }
 
+func nullableLimit(limit int64) *int64 {
+	if limit <= 0 {
+		return nil
+	}
+	return &limit
+}
+
+// primaryConnection returns the connection used to reflect the outputs of a cron,
+// fan-out crons default to their first target
+func primaryConnection(db *Database, input CronCreate) (int64, error) {
+	if input.ConnectionId != 0 {
+		return input.ConnectionId, nil
+	}
+	if len(input.ConnectionIds) > 0 {
+		return input.ConnectionIds[0], nil
+	}
+	if input.ConnectionTag != "" {
+		tagged, err := GetConnections(db, &input.ConnectionTag)
+		if err != nil {
+			return 0, errors.Wrap(err, "Error getting tagged connections")
+		}
+		if len(tagged) == 0 {
+			return 0, invalidInput(fmt.Errorf("no connection tagged %s", input.ConnectionTag))
+		}
+		return tagged[0].ConnectionId, nil
+	}
+	return 0, invalidInput(fmt.Errorf("no connection selected"))
+}
+
 func AddCron(db *Database, cons Connections, input CronCreate) (*Cron, error) {
+	if err := ValidateCommand(input.Command); err != nil {
+		return nil, errors.Wrap(invalidInput(err), "Invalid command")
+	}
+	if err := validateRollups(input.Rollups); err != nil {
+		return nil, errors.Wrap(invalidInput(err), "Invalid rollups")
+	}
+	var partitioning *string
+	if input.Partitioning != "" {
+		if !Partitionings[input.Partitioning] {
+			return nil, errors.Wrap(invalidInput(fmt.Errorf("unknown partitioning %s", input.Partitioning)), "Invalid partitioning")
+		}
+		partitioning = &input.Partitioning
+	}
+
+	fanOut := input.FanOut()
+	var connectionTag *string
+	if input.ConnectionTag != "" {
+		connectionTag = &input.ConnectionTag
+	}
+
+	var err error
+	input.ConnectionId, err = primaryConnection(db, input)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error selecting connection")
+	}
+
 	con, ok := cons[input.ConnectionId]
 	if !ok {
-		return nil, fmt.Errorf("connection %d not found", input.ConnectionId)
+		return nil, errors.Wrap(invalidInput(fmt.Errorf("connection %d not found", input.ConnectionId)), "Invalid connection")
+	}
+	for _, id := range input.ConnectionIds {
+		if _, ok := cons[id]; !ok {
+			return nil, errors.Wrap(invalidInput(fmt.Errorf("connection %d not found", id)), "Invalid connection")
+		}
 	}
 
 	// Create Cron in DB
 	row := db.QueryRow(
-		"INSERT INTO crons (connection_id, name, command, schedule, slug) VALUES ($1, $2, $3, $4, $5) RETURNING cron_id;",
+		"INSERT INTO crons (connection_id, name, command, schedule, slug, fan_out, connection_tag, max_rows, max_bytes, retention_days, partitioning, hypertable) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING cron_id;",
 		input.ConnectionId,
 		input.Name,
 		input.Command,
 		input.Schedule,
 		input.TableName(),
+		fanOut,
+		connectionTag,
+		nullableLimit(input.MaxRows),
+		nullableLimit(input.MaxBytes),
+		nullableLimit(input.RetentionDays),
+		partitioning,
+		// Explicit partitioning opts out of hypertables
+		db.Timescale && partitioning == nil,
 	)
 	var cronId int64
-	err := row.Scan(&cronId)
+	err = row.Scan(&cronId)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error getting cron ID")
 	}
 
+	if len(input.ConnectionIds) > 0 {
+		if err := setCronConnections(db, cronId, input.ConnectionId, input.ConnectionIds); err != nil {
+			_, _ = db.Exec("DELETE FROM crons WHERE cron_id = $1;", cronId)
+			return nil, errors.Wrap(err, "Error saving cron connections")
+		}
+	}
+
 	cron, err := GetCron(db, cronId)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error getting cron")
@@ -152,6 +276,7 @@ func AddCron(db *Database, cons Connections, input CronCreate) (*Cron, error) {
 	// Create Cron table
 	outputs, err := createCronTable(db, con, *cron)
 	if err != nil {
+		_, _ = db.Exec("DELETE FROM cron_connections WHERE cron_id = $1;", cron.CronId)
 		_, _ = db.Exec("DELETE FROM crons WHERE cron_id = $1;", cron.CronId)
 		return nil, errors.Wrap(err, "Error creating cron table")
 	}
@@ -174,14 +299,95 @@ func AddCron(db *Database, cons Connections, input CronCreate) (*Cron, error) {
 
 	if _, err := tx.Exec(insertQuery, params...); err != nil {
 		_ = tx.Rollback()
+		discardCron(db, *cron, nil)
 		return nil, errors.Wrap(err, "Error inserting cron outputs")
 	}
+	if err := tx.Commit(); err != nil {
+		discardCron(db, *cron, nil)
+		return nil, errors.Wrap(err, "Error saving cron outputs")
+	}
+
+	if err := addCronRollups(db, *cron, outputs, input.Rollups); err != nil {
+		discardCron(db, *cron, input.Rollups)
+		return nil, errors.Wrap(err, "Error creating cron rollups")
+	}
+
+	return cron, nil
+}
+
+// discardCron removes what a failed creation left behind: the tables of the cron and
+// of its rollups, its outputs, its connections and the cron itself
+func discardCron(db *Database, cron Cron, resolutions []string) {
+	for _, resolution := range resolutions {
+		rollup := CronRollup{CronId: cron.CronId, Resolution: resolution}
+		// Continuous aggregates go with the hypertable
+		if !cron.Hypertable {
+			_, _ = db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS crons_data.%s;", rollup.TableName(cron)))
+		}
+	}
+	if _, err := db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS crons_data.%s CASCADE;", cron.Slug)); err != nil {
+		slog.Error("Error dropping cron table", slog.Int64("id", cron.CronId), slog.Any("error", err))
+	}
+	_, _ = db.Exec("DELETE FROM cron_rollups WHERE cron_id = $1;", cron.CronId)
+	_, _ = db.Exec("DELETE FROM cron_outputs WHERE cron_id = $1;", cron.CronId)
+	_, _ = db.Exec("DELETE FROM cron_connections WHERE cron_id = $1;", cron.CronId)
+	_, _ = db.Exec("DELETE FROM crons WHERE cron_id = $1;", cron.CronId)
+}
+
+func setCronConnections(db *Database, cronId int64, primaryId int64, connectionIds []int64) error {
+	insertQuery := "INSERT INTO cron_connections (cron_id, connection_id) VALUES ($1, $2)"
+	params := []interface{}{cronId, primaryId}
+
+	for _, id := range connectionIds {
+		if id == primaryId {
+			continue
+		}
+		insertQuery += fmt.Sprintf(",($1,$%d)", len(params)+1)
+		params = append(params, id)
+	}
+	insertQuery += " ON CONFLICT DO NOTHING;"
+
+	_, err := db.Exec(insertQuery, params...)
+	return err
+}
+
+// GetCronConnections returns the ids of the connections the cron runs against.
+func GetCronConnections(db *Database, cron Cron) ([]int64, error) {
+	if !cron.FanOut {
+		return []int64{cron.ConnectionId}, nil
+	}
 
-	return cron, tx.Commit()
+	rows, err := db.Query(`
+		SELECT connection_id FROM cron_connections WHERE cron_id = $1
+		UNION
+		SELECT connection_id FROM connections WHERE $2 = ANY(tags) AND deleted_at IS NULL
+		ORDER BY connection_id`,
+		cron.CronId,
+		cron.ConnectionTag,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting cron connections")
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("Error closing rows", slog.Any("error", err))
+		}
+	}()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "Error scanning cron connection")
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 func updateCronLastRun(db *Database, cronId int64) (*time.Time, error) {
-	now := time.Now()
+	// Saved times only keep microseconds, a run's samples must compare equal to it
+	now := time.Now().Truncate(time.Microsecond)
 	// Update last run time of cron
 	if _, err := db.Exec("UPDATE crons SET last_run_at = $1 WHERE cron_id = $2", now, cronId); err != nil {
 		return nil, errors.Wrap(err, "Error updating cron last run")
@@ -189,6 +395,166 @@ func updateCronLastRun(db *Database, cronId int64) (*time.Time, error) {
 	return &now, nil
 }
 
+// valueSize estimates the memory used by a result value
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	default:
+		return 8
+	}
+}
+
+func setCronError(db *Database, cronId int64, cronErr error) error {
+	var lastError *string
+	if cronErr != nil {
+		msg := cronErr.Error()
+		lastError = &msg
+	}
+	_, err := db.Exec("UPDATE crons SET last_error = $1 WHERE cron_id = $2", lastError, cronId)
+	return err
+}
+
+// SchemaChangedError is returned when the command of a cron no longer gives the columns of its table
+type SchemaChangedError struct {
+	Added   []string
+	Removed []string
+}
+
+func (e *SchemaChangedError) Error() string {
+	return fmt.Sprintf("schema changed, added columns: [%s], removed columns: [%s]", strings.Join(e.Added, ", "), strings.Join(e.Removed, ", "))
+}
+
+func checkSchema(outputs []CronOutput, cols []string) error {
+	var added, removed []string
+	for _, col := range cols {
+		if !slices.ContainsFunc(outputs, func(o CronOutput) bool { return strings.EqualFold(o.Name, col) }) {
+			added = append(added, col)
+		}
+	}
+	for _, output := range outputs {
+		if !slices.ContainsFunc(cols, func(col string) bool { return strings.EqualFold(output.Name, col) }) {
+			removed = append(removed, output.Name)
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		return &SchemaChangedError{added, removed}
+	}
+	return nil
+}
+
+// runCron streams the results of a cron into its table by batches, in a single transaction
+// so that a run going over its limits doesn't leave partial results behind. The saved rows
+// are counted, and returned as well when something observes the samples
+func runCron(ctx context.Context, db *Database, con *sqle.DB, cron Cron, outputs []CronOutput, now time.Time, connectionId int64) (int64, []Object, error) {
+	maxRows, maxBytes := cron.Limits(db.Settings)
+
+	rows, err := con.QueryContext(ctx, cron.Command)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("Error closing rows", slog.Any("error", err))
+		}
+	}()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := checkSchema(outputs, cols); err != nil {
+		return 0, nil, err
+	}
+	copyCols := []string{"timestamp"}
+	if cron.FanOut {
+		copyCols = append(copyCols, "connection_id")
+	}
+	for _, col := range cols {
+		copyCols = append(copyCols, ColumnName(col))
+	}
+	table := pgx.Identifier{"crons_data", strings.ToLower(cron.Slug)}
+
+	tx, err := db.PgxPool.Begin(ctx)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "Error starting transaction")
+	}
+	defer func() {
+		// No-op once committed
+		_ = tx.Rollback(ctx)
+	}()
+
+	var batch [][]interface{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := tx.CopyFrom(ctx, table, copyCols, pgx.CopyFromRows(batch))
+		batch = batch[:0]
+		return err
+	}
+
+	observed := db.observed()
+	var saved []Object
+	var rowCount, byteCount int64
+	scanSlots := make([]interface{}, len(cols))
+	for rows.Next() {
+		rowCount++
+		if maxRows > 0 && rowCount > maxRows {
+			return 0, nil, fmt.Errorf("run stopped, more than %d rows returned", maxRows)
+		}
+
+		for i := range cols {
+			scanSlots[i] = new(interface{})
+		}
+		if err := rows.Scan(scanSlots...); err != nil {
+			return 0, nil, err
+		}
+
+		row := make([]interface{}, 0, len(copyCols))
+		row = append(row, now)
+		if cron.FanOut {
+			row = append(row, connectionId)
+		}
+		for i := range cols {
+			value := *scanSlots[i].(*interface{})
+			byteCount += valueSize(value)
+			row = append(row, value)
+		}
+		if maxBytes > 0 && byteCount > maxBytes {
+			return 0, nil, fmt.Errorf("run stopped, more than %d bytes returned", maxBytes)
+		}
+
+		batch = append(batch, row)
+		if observed {
+			object := make(Object, len(copyCols))
+			for i, col := range copyCols {
+				object[col] = row[i]
+			}
+			saved = append(saved, object)
+		}
+		if len(batch) >= copyBatchSize {
+			if err := flush(); err != nil {
+				return 0, nil, errors.Wrap(err, "Error copying cron results")
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	if err := flush(); err != nil {
+		return 0, nil, errors.Wrap(err, "Error copying cron results")
+	}
+
+	slog.Debug("Saved cron results", slog.Int64("id", cron.CronId), slog.Int64("rows", rowCount), slog.Int64("bytes", byteCount))
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, err
+	}
+	return rowCount, saved, nil
+}
+
 func ExecuteCrons(db *Database, cons Connections) error {
 	slog.Debug("Executing crons")
 	var crons []Cron
@@ -206,13 +572,25 @@ func ExecuteCrons(db *Database, cons Connections) error {
 			continue
 		}
 
-		con, ok := cons[cron.ConnectionId]
-		if !ok {
-			slog.Error("connection not found", slog.Int64("id", cron.ConnectionId))
+		connectionIds, err := GetCronConnections(db, cron)
+		if err != nil {
+			slog.Error("Error getting cron connections", slog.Int64("id", cron.CronId), slog.Any("error", err))
 			continue
 		}
 
-		slog.Info("Executing cron", slog.Int64("id", cron.CronId))
+		var targets []int64
+		for _, id := range connectionIds {
+			if _, ok := cons[id]; !ok {
+				slog.Error("connection not found", slog.Int64("id", id), slog.Int64("cron_id", cron.CronId))
+				continue
+			}
+			targets = append(targets, id)
+		}
+		if len(targets) == 0 {
+			continue
+		}
+
+		slog.Info("Executing cron", slog.Int64("id", cron.CronId), slog.Int("connections", len(targets)))
 
 		now, err := updateCronLastRun(db, cron.CronId)
 		if err != nil {
@@ -220,38 +598,58 @@ func ExecuteCrons(db *Database, cons Connections) error {
 			continue
 		}
 
-		objects, cols, err := executeCron(con, cron)
+		outputs, err := GetCronOutputs(db, cron.CronId)
 		if err != nil {
-			slog.Error("Error executing cron", slog.Int64("id", cron.CronId), slog.Any("error", err))
+			slog.Error("Error getting cron outputs", slog.Int64("id", cron.CronId), slog.Any("error", err))
 			continue
 		}
-		slog.Info("Saving results for cron", slog.Int64("id", cron.CronId))
-		insertQuery := fmt.Sprintf("INSERT INTO crons_data.%s (timestamp", cron.Slug)
-		for _, col := range cols {
-			insertQuery += "," + col
-		}
-		insertQuery += ") VALUES "
-
-		var params []interface{}
-		argCounter := 1
-		for i := range objects {
-			insertQuery += fmt.Sprintf("($%d,", argCounter)
-			argCounter++
-			params = append(params, now)
-			for _, col := range cols {
-				insertQuery += fmt.Sprintf("$%d,", argCounter)
-				argCounter++
-				params = append(params, objects[i][col])
+		labels, metrics := splitOutputs(cron, outputs)
+
+		// A failing connection must not prevent the others from being saved
+		var runErrors []string
+		for _, connectionId := range targets {
+			con := cons[connectionId]
+			rowCount, saved, err := runCron(context.Background(), db, con, cron, outputs, *now, connectionId)
+			if err := saveCronRun(db, cron, connectionId, *now, rowCount, err); err != nil {
+				slog.Error("Error saving cron run", slog.Int64("id", cron.CronId), slog.Int64("connection_id", connectionId), slog.Any("error", err))
+			}
+			if err != nil {
+				slog.Error("Error executing cron", slog.Int64("id", cron.CronId), slog.Int64("connection_id", connectionId), slog.Any("error", err))
+				runErrors = append(runErrors, fmt.Sprintf("connection %d: %s", connectionId, err))
+				db.emit(cronEvent(EventRunFailed, cron, connectionId, map[string]interface{}{"error": err.Error()}))
+
+				var schemaErr *SchemaChangedError
+				if errors.As(err, &schemaErr) {
+					db.emit(cronEvent(EventSchemaChanged, cron, connectionId, map[string]interface{}{
+						"added":   schemaErr.Added,
+						"removed": schemaErr.Removed,
+					}))
+				} else if pingErr := con.Ping(); pingErr != nil {
+					if err := markConnectionDown(db, connectionId, pingErr); err != nil {
+						slog.Error("Error saving connection error", slog.Int64("connection_id", connectionId), slog.Any("error", err))
+					}
+				}
+				continue
 			}
-			insertQuery = insertQuery[:len(insertQuery)-1] + "),"
-		}
-		insertQuery = insertQuery[:len(insertQuery)-1] + ";"
 
-		slog.Debug("Inserting cron results", slog.String("query", insertQuery), slog.Int("params_count", len(params)), slog.Any("params", params))
+			db.emit(cronEvent(EventRunSucceeded, cron, connectionId, map[string]interface{}{"rows": rowCount}))
+			if db.observed() {
+				db.notify(SampleBatch{cron, connectionId, *now, labels, metrics, saved})
+			}
+		}
 
-		if _, err := db.Exec(insertQuery, params...); err != nil {
-			slog.Error("Error inserting cron", slog.Int64("id", cron.CronId), slog.Any("error", err))
-			continue
+		var runErr error
+		if len(runErrors) > 0 {
+			runErr = fmt.Errorf("%s", strings.Join(runErrors, "; "))
+		}
+		if err := setCronError(db, cron.CronId, runErr); err != nil {
+			slog.Error("Error saving cron error", slog.Int64("id", cron.CronId), slog.Any("error", err))
+		}
+		if err := evaluateAnomalyDetectors(db, cron, outputs, *now); err != nil {
+			slog.Error("Error evaluating anomaly detectors", slog.Int64("id", cron.CronId), slog.Any("error", err))
+		}
+		if err := evaluateAlertRules(db, cron, outputs, *now); err != nil {
+			slog.Error("Error evaluating alert rules", slog.Int64("id", cron.CronId), slog.Any("error", err))
 		}
 		slog.Info("Cron executed", slog.Int64("id", cron.CronId))
 	}
@@ -259,15 +657,20 @@ func ExecuteCrons(db *Database, cons Connections) error {
 	return nil
 }
 
-func GetCrons(db *Database, connectionId *int64) ([]Cron, error) {
+func GetCrons(db *Database, connectionId *int64, tag *string) ([]Cron, error) {
 	var crons []Cron
 	query := "SELECT * FROM crons WHERE deleted_at IS NULL"
 	var args []interface{}
 
 	if connectionId != nil {
-		query += " AND connection_id = $1"
 		args = append(args, *connectionId)
+		query += fmt.Sprintf(" AND (connection_id = $%[1]d OR cron_id IN (SELECT cron_id FROM cron_connections WHERE connection_id = $%[1]d))", len(args))
 	}
+	if tag != nil {
+		args = append(args, *tag)
+		query += fmt.Sprintf(" AND (connection_tag = $%[1]d OR connection_id IN (SELECT connection_id FROM connections WHERE $%[1]d = ANY(tags)))", len(args))
+	}
+	query += " ORDER BY cron_id"
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -291,6 +694,18 @@ func GetCron(db *Database, cronId int64) (*Cron, error) {
 	return &cron, nil
 }
 
+func GetCronBySlug(db *Database, slug string) (*Cron, error) {
+	var cron Cron
+	row := db.QueryRow("SELECT * FROM crons WHERE slug = $1 AND deleted_at IS NULL", slug)
+	if err := row.Bind(&cron); err != nil {
+		return nil, errors.Wrap(err, "Error binding cron")
+	}
+	if cron.CronId == 0 {
+		return nil, fmt.Errorf("cron %s not found", slug)
+	}
+	return &cron, nil
+}
+
 func GetCronOutputs(db *Database, cronId int64) ([]CronOutput, error) {
 	var outputs []CronOutput
 	rows, err := db.Query("SELECT * FROM cron_outputs WHERE cron_id = $1", cronId)
@@ -313,6 +728,10 @@ func DeleteCron(db *Database, cronId int64) error {
 }
 
 func UpdateCron(db *Database, con *sqle.DB, cron Cron) error {
+	if err := ValidateCommand(cron.Command); err != nil {
+		return err
+	}
+
 	// Get current state
 	tx, err := db.BeginTx(context.TODO(), nil)
 	if err != nil {
@@ -356,16 +775,3 @@ func UpdateCron(db *Database, con *sqle.DB, cron Cron) error {
 
 	return tx.Commit()
 }
-
-func GetCronData(db *Database, slug string) ([]CronData, error) {
-	var data []CronData
-	query := fmt.Sprintf("SELECT * FROM crons_data.%s ORDER BY timestamp DESC", slug)
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, errors.Wrap(err, "Error getting cron data")
-	}
-	if err := rows.Bind(&data); err != nil {
-		return nil, errors.Wrap(err, "Error binding cron data")
-	}
-	return data, nil
-}
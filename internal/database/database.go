@@ -10,12 +10,27 @@ import (
 	"github.com/yaitoo/sqle"
 )
 
+// Settings are the instance wide defaults, some of them can be overridden per cron
+type Settings struct {
+	// Caps on the results of a single cron run, 0 disables them
+	MaxRows  int64
+	MaxBytes int64
+	// Days of data kept per cron, 0 keeps everything
+	RetentionDays int64
+}
+
 type Database struct {
-	PgxPool *pgxpool.Pool
+	PgxPool  *pgxpool.Pool
+	Settings Settings
+	// New cron tables become hypertables when the extension is installed
+	Timescale bool
 	*sqle.DB
+
+	observers      []SampleObserver
+	eventObservers []EventObserver
 }
 
-func Setup(ctx context.Context, dbUrl string) (*Database, error) {
+func Setup(ctx context.Context, dbUrl string, settings Settings) (*Database, error) {
 	slog.Debug("Opening database", slog.String("url", dbUrl))
 	pgxConfig, err := pgxpool.ParseConfig(dbUrl)
 	if err != nil {
@@ -28,7 +43,7 @@ func Setup(ctx context.Context, dbUrl string) (*Database, error) {
 
 	sqlDb := pgxStdlib.OpenDBFromPool(pgxPool)
 	sqleDb := sqle.Open(sqlDb)
-	db := &Database{PgxPool: pgxPool, DB: sqleDb}
+	db := &Database{PgxPool: pgxPool, Settings: settings, DB: sqleDb}
 
 	slog.Info("Checking for migrations")
 	if err := db.Migrate(ctx); err != nil {
@@ -36,5 +51,13 @@ func Setup(ctx context.Context, dbUrl string) (*Database, error) {
 	}
 	slog.Info("Database migrated")
 
+	db.Timescale, err = hasTimescale(db)
+	if err != nil {
+		return nil, fmt.Errorf("db: failed to check for timescaledb: %w", err)
+	}
+	if db.Timescale {
+		slog.Info("TimescaleDB detected, cron tables will be hypertables")
+	}
+
 	return db, nil
 }
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	EventRunSucceeded   = "run_succeeded"
+	EventRunFailed      = "run_failed"
+	EventSchemaChanged  = "schema_changed"
+	EventConnectionDown = "connection_down"
+	EventAlertFiring    = "alert_firing"
+	EventAlertResolved  = "alert_resolved"
+	EventAnomaly        = "anomaly_detected"
+)
+
+var EventTypes = []string{
+	EventRunSucceeded, EventRunFailed, EventSchemaChanged, EventConnectionDown,
+	EventAlertFiring, EventAlertResolved, EventAnomaly,
+}
+
+// Event is something that happened to a cron or a connection, for the components
+// notifying the outside world
+type Event struct {
+	Type         string                 `json:"event"`
+	Time         time.Time              `json:"time"`
+	CronId       *int64                 `json:"cron_id,omitempty"`
+	CronName     string                 `json:"cron_name,omitempty"`
+	CronSlug     string                 `json:"cron_slug,omitempty"`
+	ConnectionId *int64                 `json:"connection_id,omitempty"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+}
+
+func cronEvent(eventType string, cron Cron, connectionId int64, data map[string]interface{}) Event {
+	return Event{
+		Type:         eventType,
+		Time:         time.Now(),
+		CronId:       &cron.CronId,
+		CronName:     cron.Name,
+		CronSlug:     cron.Slug,
+		ConnectionId: &connectionId,
+		Data:         data,
+	}
+}
+
+// EventObserver is called synchronously by whatever emits the event and must not block
+type EventObserver func(event Event)
+
+func (db *Database) AddEventObserver(observer EventObserver) {
+	db.eventObservers = append(db.eventObservers, observer)
+}
+
+func (db *Database) emit(event Event) {
+	slog.Debug("Event", slog.String("type", event.Type), slog.Any("cron_id", event.CronId), slog.Any("connection_id", event.ConnectionId))
+	for _, observer := range db.eventObservers {
+		observer(event)
+	}
+}
+
+// markConnectionDown saves the error of a connection, connection_down is only
+// emitted when it was up until now
+func markConnectionDown(db *Database, connectionId int64, cause error) error {
+	res, err := db.Exec(
+		"UPDATE connections SET connected = false, last_error = $1 WHERE connection_id = $2 AND connected",
+		cause.Error(), connectionId,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		_, err := db.Exec("UPDATE connections SET last_error = $1 WHERE connection_id = $2", cause.Error(), connectionId)
+		return err
+	}
+
+	db.emit(Event{
+		Type:         EventConnectionDown,
+		Time:         time.Now(),
+		ConnectionId: &connectionId,
+		Data:         map[string]interface{}{"error": cause.Error()},
+	})
+	return nil
+}
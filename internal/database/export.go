@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DataWriter receives the rows of an export as they are read
+type DataWriter interface {
+	// Begin is called once the query succeeded, before any row
+	Begin(cols []*sql.ColumnType) error
+	Write(values []interface{}) error
+}
+
+// StreamCronData writes every sample of a query to w, most recent first,
+// the limit and cursor of the query are ignored
+func StreamCronData(ctx context.Context, db *Database, cron Cron, outputs []CronOutput, q DataQuery, w DataWriter) error {
+	sel, err := buildDataSelect(db, cron, outputs, q, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM (%s) AS export ORDER BY %s", sel.columns, sel.query, sel.orderBy)
+	rows, err := db.QueryContext(ctx, query, sel.args...)
+	if err != nil {
+		return errors.Wrap(err, "Error querying cron data")
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("Error closing rows", slog.Any("error", err))
+		}
+	}()
+
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	if err := w.Begin(cols); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(cols))
+	scanSlots := make([]interface{}, len(cols))
+	for i := range values {
+		scanSlots[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(scanSlots...); err != nil {
+			return err
+		}
+		if err := w.Write(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,312 @@
+package database
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// Rejected lines listed in an import report, the others are only counted
+const importMaxRejections = 100
+
+// Layouts tried in order when an import doesn't give its own
+var DefaultTimeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+type CronImport struct {
+	// CSV column holding the time of the samples
+	TimestampColumn string
+	// Go time layouts tried in order, times without a zone are UTC
+	TimeFormats []string
+	// CSV column to cron output, columns are matched by name when empty,
+	// otherwise unmapped columns are ignored
+	Mapping map[string]string
+	DryRun  bool
+}
+
+type ImportRejection struct {
+	Line  int
+	Error string
+}
+
+type ImportReport struct {
+	DryRun     bool
+	Imported   int64
+	Rejected   int64
+	Rejections []ImportRejection
+	From       *time.Time
+	To         *time.Time
+}
+
+func (r *ImportReport) reject(line int, err error) {
+	r.Rejected++
+	if len(r.Rejections) < importMaxRejections {
+		r.Rejections = append(r.Rejections, ImportRejection{line, err.Error()})
+	}
+}
+
+// importColumn is a CSV column copied into the cron table
+type importColumn struct {
+	index  int
+	output CronOutput
+}
+
+func parseImportValue(output CronOutput, value string) (interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+	switch output.Type {
+	case "INTEGER":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not an integer", output.Name, value)
+		}
+		return v, nil
+	case "REAL":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not a number", output.Name, value)
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+func parseImportTime(formats []string, value string) (time.Time, error) {
+	for _, format := range formats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("timestamp: %q matches none of the formats", value)
+}
+
+func importRow(t time.Time, columns []importColumn, record []string) ([]interface{}, error) {
+	row := make([]interface{}, 0, len(columns)+1)
+	row = append(row, t)
+	for _, col := range columns {
+		value, err := parseImportValue(col.output, record[col.index])
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, value)
+	}
+	return row, nil
+}
+
+// importColumns maps the header of a CSV to the outputs of a cron
+func importColumns(cron Cron, outputs []CronOutput, header []string, opts CronImport) (int, []importColumn, error) {
+	// Outputs are matched case insensitively, like their columns
+	byName := make(map[string]CronOutput)
+	for _, output := range outputs {
+		byName[output.Column()] = output
+	}
+	// Fan-out crons tell their rows apart by connection
+	if cron.FanOut {
+		byName["connection_id"] = CronOutput{cron.CronId, "connection_id", "INTEGER"}
+	}
+
+	timeIndex := -1
+	var columns []importColumn
+	seen := make(map[string]bool)
+	for i, col := range header {
+		if col == opts.TimestampColumn {
+			timeIndex = i
+			continue
+		}
+
+		target := col
+		if len(opts.Mapping) > 0 {
+			var ok bool
+			if target, ok = opts.Mapping[col]; !ok {
+				continue
+			}
+		}
+		output, ok := byName[ColumnName(target)]
+		if !ok {
+			return 0, nil, fmt.Errorf("column %s matches no output of the cron", col)
+		}
+		if seen[output.Name] {
+			return 0, nil, fmt.Errorf("output %s is mapped twice", output.Name)
+		}
+		seen[output.Name] = true
+		columns = append(columns, importColumn{i, output})
+	}
+
+	if timeIndex < 0 {
+		return 0, nil, fmt.Errorf("no %s column", opts.TimestampColumn)
+	}
+	if len(columns) == 0 {
+		return 0, nil, fmt.Errorf("no column to import")
+	}
+	return timeIndex, columns, nil
+}
+
+// rewindRollups makes the next update re-aggregate the buckets of imported samples
+func rewindRollups(ctx context.Context, tx pgx.Tx, cron Cron, from time.Time) error {
+	_, err := tx.Exec(
+		ctx,
+		"UPDATE cron_rollups SET last_rolled_up_at = date_trunc(resolution, $1::timestamptz) WHERE cron_id = $2 AND last_rolled_up_at > date_trunc(resolution, $1::timestamptz)",
+		from, cron.CronId,
+	)
+	return err
+}
+
+// refreshContinuousAggregates is the hypertable version of rewindRollups,
+// it can't run inside a transaction
+func refreshContinuousAggregates(db *Database, cron Cron, from time.Time, to time.Time) error {
+	rollups, err := GetCronRollups(db, cron.CronId)
+	if err != nil {
+		return err
+	}
+	for _, rollup := range rollups {
+		length := Resolutions[rollup.Resolution]
+		_, err := db.Exec(
+			"CALL refresh_continuous_aggregate($1, $2::timestamptz, $3::timestamptz)",
+			"crons_data."+rollup.TableName(cron), from.Add(-length), to.Add(length),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Error refreshing %s rollup", rollup.Resolution)
+		}
+	}
+	return nil
+}
+
+// ImportCronCSV copies the valid lines of a CSV into the table of a cron, rejected lines
+// are reported. A dry run only validates the lines.
+func ImportCronCSV(ctx context.Context, db *Database, cron Cron, outputs []CronOutput, r io.Reader, opts CronImport) (*ImportReport, error) {
+	if opts.TimestampColumn == "" {
+		opts.TimestampColumn = "timestamp"
+	}
+	if len(opts.TimeFormats) == 0 {
+		opts.TimeFormats = DefaultTimeFormats
+	}
+
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading CSV header")
+	}
+	timeIndex, columns, err := importColumns(cron, outputs, header, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	copyCols := []string{"timestamp"}
+	for _, col := range columns {
+		copyCols = append(copyCols, col.output.Column())
+	}
+	table := pgx.Identifier{"crons_data", strings.ToLower(cron.Slug)}
+
+	tx, err := db.PgxPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error starting transaction")
+	}
+	defer func() {
+		// No-op once committed
+		_ = tx.Rollback(ctx)
+	}()
+
+	report := &ImportReport{DryRun: opts.DryRun}
+	partitions := make(map[time.Time]bool)
+	var batch [][]interface{}
+	flush := func() error {
+		if len(batch) == 0 || opts.DryRun {
+			batch = batch[:0]
+			return nil
+		}
+		_, err := tx.CopyFrom(ctx, table, copyCols, pgx.CopyFromRows(batch))
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				report.reject(parseErr.Line, parseErr.Err)
+				continue
+			}
+			return nil, errors.Wrap(err, "Error reading CSV")
+		}
+		line, _ := reader.FieldPos(0)
+
+		t, err := parseImportTime(opts.TimeFormats, record[timeIndex])
+		if err != nil {
+			report.reject(line, err)
+			continue
+		}
+		row, err := importRow(t, columns, record)
+		if err != nil {
+			report.reject(line, err)
+			continue
+		}
+
+		// Partitions are created outside of the transaction, an empty one is harmless
+		if cron.Partitioning != nil && !opts.DryRun {
+			start := partitionStart(*cron.Partitioning, t)
+			if !partitions[start] {
+				if err := createPartition(db, cron, start); err != nil {
+					return nil, errors.Wrapf(err, "Error creating partition %s", partitionName(cron, start))
+				}
+				partitions[start] = true
+			}
+		}
+
+		if report.From == nil || t.Before(*report.From) {
+			report.From = &t
+		}
+		if report.To == nil || t.After(*report.To) {
+			report.To = &t
+		}
+		report.Imported++
+		batch = append(batch, row)
+		if len(batch) >= copyBatchSize {
+			if err := flush(); err != nil {
+				return nil, errors.Wrap(err, "Error copying imported samples")
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, errors.Wrap(err, "Error copying imported samples")
+	}
+
+	if opts.DryRun || report.Imported == 0 {
+		return report, nil
+	}
+	if !cron.Hypertable {
+		if err := rewindRollups(ctx, tx, cron, *report.From); err != nil {
+			return nil, errors.Wrap(err, "Error rewinding rollups")
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.Wrap(err, "Error committing import")
+	}
+	if cron.Hypertable {
+		if err := refreshContinuousAggregates(db, cron, *report.From, *report.To); err != nil {
+			slog.Error("Error refreshing continuous aggregates", slog.Int64("id", cron.CronId), slog.Any("error", err))
+		}
+	}
+
+	slog.Info("Imported cron samples", slog.Int64("id", cron.CronId), slog.Int64("rows", report.Imported), slog.Int64("rejected", report.Rejected))
+	return report, nil
+}
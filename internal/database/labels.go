@@ -0,0 +1,82 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+// Distinct values read per cron when listing the values of a label
+const labelValuesLimit = 1000
+
+// GetLabelNames lists the label columns of every cron
+func GetLabelNames(db *Database) ([]string, error) {
+	crons, err := GetCrons(db, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting crons")
+	}
+
+	var names []string
+	for _, cron := range crons {
+		outputs, err := GetCronOutputs(db, cron.CronId)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error getting outputs of cron %d", cron.CronId)
+		}
+		for _, label := range cron.Labels(outputs) {
+			if !slices.Contains(names, label) {
+				names = append(names, label)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// GetLabelValues lists the values of a label across the crons having it
+func GetLabelValues(db *Database, label string) ([]string, error) {
+	crons, err := GetCrons(db, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting crons")
+	}
+
+	var values []string
+	for _, cron := range crons {
+		outputs, err := GetCronOutputs(db, cron.CronId)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error getting outputs of cron %d", cron.CronId)
+		}
+		// Only known columns end up in the query
+		if !slices.Contains(cron.Labels(outputs), label) {
+			continue
+		}
+
+		rows, err := db.Query(fmt.Sprintf(
+			"SELECT DISTINCT %[1]s::TEXT FROM crons_data.%[2]s WHERE %[1]s IS NOT NULL LIMIT %[3]d",
+			label, cron.Slug, labelValuesLimit,
+		))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error getting %s values of cron %d", label, cron.CronId)
+		}
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				_ = rows.Close()
+				return nil, errors.Wrap(err, "Error scanning label value")
+			}
+			if !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+		err = rows.Err()
+		if err := rows.Close(); err != nil {
+			slog.Error("Error closing rows", slog.Any("error", err))
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading label values")
+		}
+	}
+	slices.Sort(values)
+	return values, nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var invalidPromChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// PromName makes a valid Prometheus metric or label name, which can't start with a digit
+func PromName(parts ...string) string {
+	name := invalidPromChars.ReplaceAllString(strings.Join(parts, "_"), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// LatestSamples are the rows of the last run of a cron, one per label set
+type LatestSamples struct {
+	Cron    Cron
+	Labels  []string
+	Metrics []CronOutput
+	Rows    []Object
+}
+
+// GetLatestSamples reads the last run of every cron, the runs of fan-out crons
+// share their time across connections. A cron that can't be read is logged and
+// left out.
+func GetLatestSamples(db *Database) ([]LatestSamples, error) {
+	crons, err := GetCrons(db, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting crons")
+	}
+
+	var samples []LatestSamples
+	for _, cron := range crons {
+		outputs, err := GetCronOutputs(db, cron.CronId)
+		if err != nil {
+			slog.Error("Error getting cron outputs", slog.Int64("id", cron.CronId), slog.Any("error", err))
+			continue
+		}
+		labels, metrics := splitOutputs(cron, outputs)
+		if len(metrics) == 0 {
+			continue
+		}
+
+		query := fmt.Sprintf(
+			"SELECT * FROM crons_data.%[1]s WHERE timestamp = (SELECT MAX(timestamp) FROM crons_data.%[1]s)",
+			cron.Slug,
+		)
+		rows, _, err := queryCron(context.Background(), db.DB, query, 0)
+		if err != nil {
+			slog.Error("Error getting latest samples", slog.Int64("id", cron.CronId), slog.Any("error", err))
+			continue
+		}
+		samples = append(samples, LatestSamples{cron, labels, metrics, rows})
+	}
+	return samples, nil
+}
@@ -88,6 +88,326 @@ DROP TABLE crons;
 DROP TABLE cron_outputs;
 
 DROP SCHEMA IF EXISTS crons_data CASCADE;
+`,
+		},
+		{
+			Sequence: 2,
+			Name:     "cron_fan_out",
+			UpSQL: `
+ALTER TABLE crons ADD COLUMN fan_out BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE cron_connections (
+    cron_id       INTEGER NOT NULL REFERENCES crons (cron_id),
+    connection_id INTEGER NOT NULL REFERENCES connections (connection_id),
+
+    CONSTRAINT cron_connections_pk PRIMARY KEY (cron_id, connection_id)
+);
+`,
+			DownSQL: `
+DROP TABLE cron_connections;
+
+ALTER TABLE crons DROP COLUMN fan_out;
+`,
+		},
+		{
+			Sequence: 3,
+			Name:     "connection_tags",
+			UpSQL: `
+ALTER TABLE connections ADD COLUMN name        TEXT   NOT NULL DEFAULT '';
+ALTER TABLE connections ADD COLUMN description TEXT   NOT NULL DEFAULT '';
+ALTER TABLE connections ADD COLUMN tags        TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX connections_tags ON connections USING GIN (tags);
+
+ALTER TABLE crons ADD COLUMN connection_tag TEXT;
+`,
+			DownSQL: `
+ALTER TABLE crons DROP COLUMN connection_tag;
+
+DROP INDEX connections_tags;
+
+ALTER TABLE connections DROP COLUMN tags;
+ALTER TABLE connections DROP COLUMN description;
+ALTER TABLE connections DROP COLUMN name;
+`,
+		},
+		{
+			Sequence: 4,
+			Name:     "cron_limits",
+			UpSQL: `
+ALTER TABLE crons ADD COLUMN max_rows   BIGINT;
+ALTER TABLE crons ADD COLUMN max_bytes  BIGINT;
+ALTER TABLE crons ADD COLUMN last_error TEXT;
+`,
+			DownSQL: `
+ALTER TABLE crons DROP COLUMN last_error;
+ALTER TABLE crons DROP COLUMN max_bytes;
+ALTER TABLE crons DROP COLUMN max_rows;
+`,
+		},
+		{
+			Sequence: 5,
+			Name:     "cron_retention",
+			UpSQL: `
+ALTER TABLE crons ADD COLUMN retention_days INTEGER;
+`,
+			DownSQL: `
+ALTER TABLE crons DROP COLUMN retention_days;
+`,
+		},
+		{
+			Sequence: 6,
+			Name:     "cron_rollups",
+			UpSQL: `
+CREATE TABLE cron_rollups (
+    cron_id           INTEGER NOT NULL REFERENCES crons (cron_id),
+    resolution        TEXT    NOT NULL,
+    last_rolled_up_at TIMESTAMPTZ,
+
+    CONSTRAINT cron_rollups_pk PRIMARY KEY (cron_id, resolution)
+);
+`,
+			DownSQL: `
+DROP TABLE cron_rollups;
+`,
+		},
+		{
+			Sequence: 7,
+			Name:     "cron_partitioning",
+			UpSQL: `
+ALTER TABLE crons ADD COLUMN partitioning TEXT;
+`,
+			DownSQL: `
+ALTER TABLE crons DROP COLUMN partitioning;
+`,
+		},
+		{
+			Sequence: 8,
+			Name:     "cron_hypertable",
+			UpSQL: `
+ALTER TABLE crons ADD COLUMN hypertable BOOLEAN NOT NULL DEFAULT FALSE;
+`,
+			DownSQL: `
+ALTER TABLE crons DROP COLUMN hypertable;
+`,
+		},
+		{
+			Sequence: 9,
+			Name:     "webhooks",
+			UpSQL: `
+CREATE TABLE webhooks (
+    webhook_id SERIAL PRIMARY KEY,
+    -- Global when NULL
+    cron_id    INTEGER REFERENCES crons (cron_id),
+    url        TEXT    NOT NULL,
+    secret     TEXT    NOT NULL,
+    -- Every event when empty
+    events     TEXT[]  NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE TABLE webhook_deliveries (
+    delivery_id     BIGSERIAL PRIMARY KEY,
+    webhook_id      INTEGER NOT NULL REFERENCES webhooks (webhook_id),
+    event           TEXT    NOT NULL,
+    payload         TEXT    NOT NULL,
+    status          TEXT    NOT NULL DEFAULT 'pending',
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    response_status INTEGER,
+    last_error      TEXT,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at    TIMESTAMPTZ
+);
+
+CREATE INDEX webhook_deliveries_pending ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries (webhook_id, created_at);
+`,
+			DownSQL: `
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
+`,
+		},
+		{
+			Sequence: 10,
+			Name:     "alerts",
+			UpSQL: `
+CREATE TABLE alert_rules (
+    rule_id      SERIAL PRIMARY KEY,
+    cron_id      INTEGER NOT NULL REFERENCES crons (cron_id),
+    name         TEXT    NOT NULL,
+    column_name  TEXT    NOT NULL,
+    -- Label values the series must have, every series when empty
+    label_filter JSONB   NOT NULL DEFAULT '{}',
+    condition    TEXT    NOT NULL,
+    -- Seconds for absent_for, per minute for change_rate
+    threshold    DOUBLE PRECISION NOT NULL,
+    for_seconds  INTEGER NOT NULL DEFAULT 0,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at   TIMESTAMPTZ
+);
+
+CREATE TABLE alerts (
+    alert_id    BIGSERIAL PRIMARY KEY,
+    rule_id     INTEGER NOT NULL REFERENCES alert_rules (rule_id),
+    -- Labels of the series as a JSON object
+    series      TEXT    NOT NULL,
+    state       TEXT    NOT NULL,
+    value       DOUBLE PRECISION,
+    started_at  TIMESTAMPTZ NOT NULL,
+    fired_at    TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX alerts_active ON alerts (rule_id, series) WHERE state != 'resolved';
+`,
+			DownSQL: `
+DROP TABLE alerts;
+DROP TABLE alert_rules;
+`,
+		},
+		{
+			Sequence: 11,
+			Name:     "notification_channels",
+			UpSQL: `
+CREATE TABLE notification_channels (
+    channel_id       SERIAL PRIMARY KEY,
+    name             TEXT    NOT NULL,
+    kind             TEXT    NOT NULL,
+    -- Recipients for email, incoming webhook url for slack
+    target           TEXT    NOT NULL,
+    -- Failures and alerts when empty
+    events           TEXT[]  NOT NULL DEFAULT '{}',
+    -- Routing, every cron and connection when NULL
+    cron_id          INTEGER REFERENCES crons (cron_id),
+    connection_tag   TEXT,
+    -- Default message when empty
+    template         TEXT    NOT NULL DEFAULT '',
+    throttle_seconds INTEGER NOT NULL DEFAULT 300,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at       TIMESTAMPTZ
+);
+
+CREATE TABLE notifications (
+    notification_id BIGSERIAL PRIMARY KEY,
+    channel_id      INTEGER NOT NULL REFERENCES notification_channels (channel_id),
+    event           TEXT    NOT NULL,
+    throttle_key    TEXT    NOT NULL,
+    status          TEXT    NOT NULL,
+    message         TEXT    NOT NULL,
+    error           TEXT,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX notifications_throttle ON notifications (channel_id, throttle_key, created_at);
+`,
+			DownSQL: `
+DROP TABLE notifications;
+DROP TABLE notification_channels;
+`,
+		},
+		{
+			Sequence: 12,
+			Name:     "anomaly_detection",
+			UpSQL: `
+CREATE TABLE anomaly_detectors (
+    detector_id SERIAL PRIMARY KEY,
+    cron_id     INTEGER NOT NULL REFERENCES crons (cron_id),
+    column_name TEXT    NOT NULL,
+    method      TEXT    NOT NULL,
+    threshold   DOUBLE PRECISION NOT NULL DEFAULT 3,
+    -- Samples for zscore, seasons for seasonal
+    window_size INTEGER NOT NULL,
+    season      TEXT,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at  TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX anomaly_detectors_output ON anomaly_detectors (cron_id, column_name) WHERE deleted_at IS NULL;
+
+CREATE TABLE annotations (
+    annotation_id BIGSERIAL PRIMARY KEY,
+    cron_id       INTEGER REFERENCES crons (cron_id),
+    time          TIMESTAMPTZ NOT NULL,
+    text          TEXT    NOT NULL,
+    tags          TEXT[]  NOT NULL DEFAULT '{}',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX annotations_time ON annotations (time);
+`,
+			DownSQL: `
+DROP TABLE annotations;
+DROP TABLE anomaly_detectors;
+`,
+		},
+		{
+			Sequence: 13,
+			Name:     "annotation_scopes",
+			UpSQL: `
+ALTER TABLE annotations ADD COLUMN time_end TIMESTAMPTZ;
+ALTER TABLE annotations ADD COLUMN connection_id INTEGER REFERENCES connections (connection_id);
+ALTER TABLE annotations ADD CONSTRAINT annotations_range CHECK (time_end IS NULL OR time_end >= time);
+`,
+			DownSQL: `
+ALTER TABLE annotations DROP CONSTRAINT annotations_range;
+ALTER TABLE annotations DROP COLUMN connection_id;
+ALTER TABLE annotations DROP COLUMN time_end;
+`,
+		},
+		{
+			Sequence: 14,
+			Name:     "cron_runs",
+			UpSQL: `
+CREATE TABLE cron_runs (
+	run_id SERIAL PRIMARY KEY,
+	cron_id INTEGER NOT NULL REFERENCES crons (cron_id),
+	connection_id INTEGER NOT NULL REFERENCES connections (connection_id),
+	started_at TIMESTAMPTZ NOT NULL,
+	finished_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	row_count BIGINT NOT NULL DEFAULT 0,
+	error TEXT
+);
+CREATE INDEX cron_runs_cron ON cron_runs (cron_id, started_at DESC);
+`,
+			DownSQL: `
+DROP TABLE cron_runs;
+`,
+		},
+		{
+			Sequence: 15,
+			Name:     "api_tokens",
+			UpSQL: `
+CREATE TABLE api_tokens (
+	token_id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	-- SHA-256 of the token, which is only shown once
+	token_hash TEXT NOT NULL UNIQUE,
+	prefix TEXT NOT NULL,
+	scopes TEXT[] NOT NULL,
+	expires_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	revoked_at TIMESTAMPTZ
+);
+`,
+			DownSQL: `
+DROP TABLE api_tokens;
+`,
+		},
+		{
+			Sequence: 16,
+			Name:     "anomaly_annotations_unique",
+			UpSQL: `
+DELETE FROM annotations a USING annotations b
+WHERE 'anomaly' = ANY(a.tags) AND a.cron_id = b.cron_id AND a.time = b.time AND a.tags = b.tags AND a.annotation_id > b.annotation_id;
+CREATE UNIQUE INDEX annotations_anomaly ON annotations (cron_id, time, tags) WHERE 'anomaly' = ANY(tags);
+`,
+			DownSQL: `
+DROP INDEX annotations_anomaly;
 `,
 		},
 	}
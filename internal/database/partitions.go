@@ -0,0 +1,144 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Partitions created in advance of the current one
+const partitionsAhead = 2
+
+var Partitionings = map[string]bool{
+	"week":  true,
+	"month": true,
+}
+
+// partitionStart returns the start of the partition holding t, weeks start on monday like date_trunc
+func partitionStart(partitioning string, t time.Time) time.Time {
+	t = t.UTC()
+	switch partitioning {
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func partitionEnd(partitioning string, start time.Time) time.Time {
+	switch partitioning {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+func partitionName(cron Cron, start time.Time) string {
+	return fmt.Sprintf("%s_p%s", cron.Slug, start.Format("20060102"))
+}
+
+func createPartition(db *Database, cron Cron, start time.Time) error {
+	end := partitionEnd(*cron.Partitioning, start)
+	query := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS crons_data.%s PARTITION OF crons_data.%s FOR VALUES FROM ('%s') TO ('%s');",
+		partitionName(cron, start),
+		cron.Slug,
+		start.Format(time.RFC3339),
+		end.Format(time.RFC3339),
+	)
+	slog.Debug("Cron partition creation", slog.String("query", query))
+	_, err := db.Exec(query)
+	return err
+}
+
+// ensurePartitions creates the current partition of the cron and the next ones
+func ensurePartitions(db *Database, cron Cron) error {
+	start := partitionStart(*cron.Partitioning, time.Now())
+	for range partitionsAhead + 1 {
+		if err := createPartition(db, cron, start); err != nil {
+			return errors.Wrapf(err, "Error creating partition %s", partitionName(cron, start))
+		}
+		start = partitionEnd(*cron.Partitioning, start)
+	}
+	return nil
+}
+
+// listPartitions returns the start of the existing partitions, read from their names
+func listPartitions(db *Database, cron Cron) ([]time.Time, error) {
+	rows, err := db.Query(`
+		SELECT child.relname FROM pg_inherits
+		JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+		JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+		JOIN pg_namespace ON pg_namespace.oid = parent.relnamespace
+		WHERE pg_namespace.nspname = 'crons_data' AND parent.relname = $1`,
+		strings.ToLower(cron.Slug),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error listing partitions")
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("Error closing rows", slog.Any("error", err))
+		}
+	}()
+
+	prefix := strings.ToLower(cron.Slug) + "_p"
+	var starts []time.Time
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "Error scanning partition")
+		}
+		start, err := time.Parse("20060102", strings.TrimPrefix(name, prefix))
+		if err != nil {
+			slog.Warn("Ignoring unknown partition", slog.String("name", name))
+			continue
+		}
+		starts = append(starts, start)
+	}
+	return starts, rows.Err()
+}
+
+// dropPartitionsBefore drops the partitions only holding data older than before
+func dropPartitionsBefore(db *Database, cron Cron, before time.Time) (int, error) {
+	starts, err := listPartitions(db, cron)
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	for _, start := range starts {
+		if partitionEnd(*cron.Partitioning, start).After(before) {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("DROP TABLE crons_data.%s;", partitionName(cron, start))); err != nil {
+			return dropped, errors.Wrapf(err, "Error dropping partition %s", partitionName(cron, start))
+		}
+		dropped++
+	}
+	return dropped, nil
+}
+
+// MaintainPartitions makes sure every partitioned cron has its upcoming partitions
+func MaintainPartitions(db *Database) error {
+	crons, err := GetCrons(db, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error getting crons")
+	}
+
+	for _, cron := range crons {
+		if cron.Partitioning == nil {
+			continue
+		}
+		if err := ensurePartitions(db, cron); err != nil {
+			slog.Error("Error creating partitions", slog.Int64("id", cron.CronId), slog.Any("error", err))
+		}
+	}
+
+	return nil
+}
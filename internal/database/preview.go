@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	PreviewRowLimit = 100
+	PreviewTimeout  = 10 * time.Second
+)
+
+type CronPreview struct {
+	ConnectionId int64
+	Columns      []string
+	Rows         []Object
+	Outputs      []CronOutput
+	// Type errors per column, a cron can't be created while there are any
+	Errors    map[string]string
+	Truncated bool
+}
+
+// PreviewCron runs a cron command without saving anything on Grognon's side
+func PreviewCron(db *Database, cons Connections, input CronCreate) (*CronPreview, error) {
+	if err := ValidateCommand(input.Command); err != nil {
+		return nil, err
+	}
+
+	connectionId, err := primaryConnection(db, input)
+	if err != nil {
+		return nil, err
+	}
+	con, ok := cons[connectionId]
+	if !ok {
+		return nil, fmt.Errorf("connection %d not found", connectionId)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PreviewTimeout)
+	defer cancel()
+
+	// Read an extra row to know if the results were truncated
+	objects, cols, err := queryCron(ctx, con, input.Command, PreviewRowLimit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &CronPreview{
+		ConnectionId: connectionId,
+		Columns:      cols,
+		Rows:         objects,
+		Outputs:      []CronOutput{},
+		Errors:       make(map[string]string),
+	}
+	if len(objects) > PreviewRowLimit {
+		preview.Rows = objects[:PreviewRowLimit]
+		preview.Truncated = true
+	}
+	if len(objects) == 0 {
+		preview.Errors[""] = "no rows returned"
+		return preview, nil
+	}
+
+	for _, col := range cols {
+		if err := validateOutputNames(input.FanOut(), []string{col}); err != nil {
+			preview.Errors[col] = err.Error()
+			continue
+		}
+		output, err := inferOutput(0, col, preview.Rows)
+		if err != nil {
+			preview.Errors[col] = err.Error()
+			continue
+		}
+		preview.Outputs = append(preview.Outputs, output)
+	}
+
+	return preview, nil
+}
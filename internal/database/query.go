@@ -0,0 +1,317 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	QueryDefaultLimit = 500
+	QueryMaxLimit     = 5000
+)
+
+// Aggregations of bucketed queries, on raw samples and on rollups
+var Aggregations = map[string][2]string{
+	"avg":   {"AVG(%[1]s)::DOUBLE PRECISION", "(SUM(%[1]s_avg * sample_count) / SUM(sample_count))::DOUBLE PRECISION"},
+	"min":   {"MIN(%[1]s)", "MIN(%[1]s_min)"},
+	"max":   {"MAX(%[1]s)", "MAX(%[1]s_max)"},
+	"sum":   {"SUM(%[1]s)", "SUM(%[1]s_avg * sample_count)::DOUBLE PRECISION"},
+	"count": {"COUNT(%[1]s)", "SUM(sample_count)"},
+	"last":  {"(array_agg(%[1]s ORDER BY timestamp DESC))[1]", "(array_agg(%[1]s_last ORDER BY bucket DESC))[1]"},
+}
+
+// Rollups whose buckets line up with the epoch based buckets of the queries,
+// weeks start on mondays and months vary in length
+var alignedResolutions = []string{"day", "hour"}
+
+type DataQuery struct {
+	From time.Time
+	To   time.Time
+	// Outputs to return, all of them when empty
+	Columns []string
+	// Equality filters on label columns
+	Labels map[string]string
+	// Aggregate the samples by buckets of this length, 0 returns them as they are
+	Bucket time.Duration
+	Agg    string
+	Limit  int
+	Cursor string
+}
+
+type DataPage struct {
+	Columns    []string
+	Rows       []Object
+	NextCursor string
+	// Raw samples or the rollup they were read from
+	Resolution string
+}
+
+// Column numbering the rows sharing a time, it is unique within a time and
+// breaks ties when paging
+const rowKeyColumn = "row_key"
+
+// The cursor is the time and the row key of the last row returned
+func encodeCursor(t time.Time, key int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%d", t.Format(time.RFC3339Nano), key)))
+}
+
+func decodeCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	tStr, keyStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, tStr)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	key, err := strconv.Atoi(keyStr)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return t, key, nil
+}
+
+// queryResolution picks the table a query reads from, a bucketed query can use the coarsest
+// aligned rollup its bucket is a multiple of
+func queryResolution(rollups []CronRollup, q DataQuery) string {
+	if q.Bucket <= 0 {
+		return ChooseResolution(rollups, q.From, q.To)
+	}
+	for _, resolution := range alignedResolutions {
+		if q.Bucket%Resolutions[resolution] != 0 {
+			continue
+		}
+		if slices.ContainsFunc(rollups, func(r CronRollup) bool { return r.Resolution == resolution }) {
+			return resolution
+		}
+	}
+	return "raw"
+}
+
+// dataSelect is the SQL of a data query, before paging. Its rows also hold
+// a row key, columns lists the others.
+type dataSelect struct {
+	query      string
+	args       []interface{}
+	resolution string
+	columns    string
+	// Time column of the rows
+	timeCol string
+	orderBy string
+}
+
+// buildDataSelect validates a query against the outputs of a cron, only known
+// columns end up in the SQL. Rows after until are left out, unless it is zero.
+func buildDataSelect(db *Database, cron Cron, outputs []CronOutput, q DataQuery, until time.Time) (*dataSelect, error) {
+	labels, metrics := splitOutputs(cron, outputs)
+	var metricNames []string
+	for _, metric := range metrics {
+		metricNames = append(metricNames, metric.Name)
+	}
+
+	selectedLabels, selectedMetrics := labels, metricNames
+	if len(q.Columns) > 0 {
+		selectedLabels, selectedMetrics = nil, nil
+		for _, col := range q.Columns {
+			switch {
+			case slices.Contains(labels, col):
+				selectedLabels = append(selectedLabels, col)
+			case slices.Contains(metricNames, col):
+				selectedMetrics = append(selectedMetrics, col)
+			default:
+				return nil, fmt.Errorf("unknown column %s", col)
+			}
+		}
+	}
+	for label := range q.Labels {
+		if !slices.Contains(labels, label) {
+			return nil, fmt.Errorf("unknown label %s", label)
+		}
+	}
+	if q.Bucket > 0 {
+		if _, ok := Aggregations[q.Agg]; !ok {
+			return nil, fmt.Errorf("unknown aggregation %s", q.Agg)
+		}
+		if q.Bucket < time.Second {
+			return nil, fmt.Errorf("bucket must be at least one second")
+		}
+	}
+
+	rollups, err := GetCronRollups(db, cron.CronId)
+	if err != nil {
+		return nil, err
+	}
+	resolution := queryResolution(rollups, q)
+	table, timeCol := cron.Slug, "timestamp"
+	if resolution != "raw" {
+		rollup := CronRollup{CronId: cron.CronId, Resolution: resolution}
+		table, timeCol = rollup.TableName(cron), "bucket"
+	}
+
+	args := []interface{}{q.From, q.To}
+	where := fmt.Sprintf("%[1]s >= $1 AND %[1]s < $2", timeCol)
+	for label, value := range q.Labels {
+		args = append(args, value)
+		where += fmt.Sprintf(" AND %s::TEXT = $%d", label, len(args))
+	}
+	// Whole times are kept, so that the row keys of a time don't change
+	if !until.IsZero() {
+		if q.Bucket > 0 {
+			args = append(args, until.Add(q.Bucket))
+			where += fmt.Sprintf(" AND %s < $%d", timeCol, len(args))
+		} else {
+			args = append(args, until)
+			where += fmt.Sprintf(" AND %s <= $%d", timeCol, len(args))
+		}
+	}
+
+	// Rows sharing a time are numbered by label, raw samples can repeat
+	// their labels so their physical location breaks the remaining ties
+	var query, columns string
+	outTime := timeCol
+	keyOrder := strings.Join(selectedLabels, ",")
+	switch {
+	case q.Bucket > 0:
+		outTime = "bucket"
+		secs := int64(q.Bucket / time.Second)
+		selects := fmt.Sprintf("to_timestamp(floor(extract(epoch FROM %[1]s) / %[2]d) * %[2]d) AS bucket", timeCol, secs)
+		groupBy := "1"
+		for i, label := range selectedLabels {
+			selects += "," + label
+			groupBy += fmt.Sprintf(",%d", i+2)
+		}
+		aggIdx := 0
+		if resolution != "raw" {
+			aggIdx = 1
+		}
+		columns = "bucket"
+		for _, label := range selectedLabels {
+			columns += "," + label
+		}
+		for _, metric := range selectedMetrics {
+			selects += "," + fmt.Sprintf(Aggregations[q.Agg][aggIdx], metric) + " AS " + metric
+			columns += "," + metric
+		}
+		// Buckets are grouped by the selected labels, which are unique within a bucket
+		if keyOrder == "" {
+			keyOrder = "bucket"
+		}
+		query = fmt.Sprintf(
+			"SELECT *,row_number() OVER (PARTITION BY bucket ORDER BY %s) AS %s FROM (SELECT %s FROM crons_data.%s WHERE %s GROUP BY %s) AS buckets",
+			keyOrder, rowKeyColumn, selects, table, where, groupBy,
+		)
+	case resolution != "raw":
+		selects := "bucket,sample_count"
+		for _, label := range selectedLabels {
+			selects += "," + label
+		}
+		for _, metric := range selectedMetrics {
+			selects += fmt.Sprintf(",%[1]s_avg,%[1]s_min,%[1]s_max,%[1]s_last", metric)
+		}
+		columns = selects
+		// Rollups hold one row per bucket and labels, continuous aggregates have no ctid
+		keyOrder = strings.Join(append(slices.Clone(selectedLabels), labels...), ",")
+		if keyOrder == "" {
+			keyOrder = "bucket"
+		}
+		query = fmt.Sprintf(
+			"SELECT %s,row_number() OVER (PARTITION BY bucket ORDER BY %s) AS %s FROM crons_data.%s WHERE %s",
+			selects, keyOrder, rowKeyColumn, table, where,
+		)
+	default:
+		selects := "timestamp"
+		for _, label := range selectedLabels {
+			selects += "," + label
+		}
+		for _, metric := range selectedMetrics {
+			selects += "," + metric
+		}
+		columns = selects
+		keyOrder = strings.Join(append(slices.Clone(selectedLabels), "ctid"), ",")
+		query = fmt.Sprintf(
+			"SELECT %s,row_number() OVER (PARTITION BY timestamp ORDER BY %s) AS %s FROM crons_data.%s WHERE %s",
+			selects, keyOrder, rowKeyColumn, table, where,
+		)
+	}
+
+	orderBy := outTime + " DESC," + rowKeyColumn
+
+	return &dataSelect{query: query, args: args, resolution: resolution, columns: columns, timeCol: outTime, orderBy: orderBy}, nil
+}
+
+// QueryCronData reads a page of the samples of a cron, most recent first
+func QueryCronData(db *Database, cron Cron, outputs []CronOutput, q DataQuery) (*DataPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = QueryDefaultLimit
+	}
+	q.Limit = min(q.Limit, QueryMaxLimit)
+
+	// The cursor bounds the samples read, not only the rows returned
+	var cursorTime time.Time
+	key := 0
+	if q.Cursor != "" {
+		var err error
+		cursorTime, key, err = decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid cursor")
+		}
+	}
+
+	sel, err := buildDataSelect(db, cron, outputs, q, cursorTime)
+	if err != nil {
+		return nil, err
+	}
+	args, outTime := sel.args, sel.timeCol
+
+	cursorWhere := ""
+	if q.Cursor != "" {
+		args = append(args, cursorTime, key)
+		cursorWhere = fmt.Sprintf(
+			" WHERE %[1]s < $%[2]d OR (%[1]s = $%[2]d AND %[3]s > $%[4]d)",
+			outTime, len(args)-1, rowKeyColumn, len(args),
+		)
+	}
+	query := fmt.Sprintf(
+		"SELECT %s,%s FROM (%s) AS page%s ORDER BY %s LIMIT %d",
+		sel.columns, rowKeyColumn, sel.query, cursorWhere, sel.orderBy, q.Limit+1,
+	)
+
+	objects, cols, err := queryCron(context.Background(), db.DB, query, 0, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error querying cron data")
+	}
+
+	page := &DataPage{Columns: slices.DeleteFunc(cols, func(col string) bool { return col == rowKeyColumn }), Rows: objects, Resolution: sel.resolution}
+	if page.Rows == nil {
+		page.Rows = []Object{}
+	}
+	if len(objects) > q.Limit {
+		page.Rows = objects[:q.Limit]
+		lastRow := page.Rows[q.Limit-1]
+		last, ok := lastRow[outTime].(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %s type %T", outTime, lastRow[outTime])
+		}
+		key, ok := lastRow[rowKeyColumn].(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %s type %T", rowKeyColumn, lastRow[rowKeyColumn])
+		}
+		page.NextCursor = encodeCursor(last, int(key))
+	}
+	for _, row := range page.Rows {
+		delete(row, rowKeyColumn)
+	}
+
+	return page, nil
+}
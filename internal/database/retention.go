@@ -0,0 +1,92 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Rows deleted per statement, to avoid holding locks on the data tables for too long
+const retentionBatchSize = 10_000
+
+func deleteCronDataBefore(db *Database, cron Cron, before time.Time) (int64, error) {
+	query := fmt.Sprintf(
+		"DELETE FROM crons_data.%[1]s WHERE ctid IN (SELECT ctid FROM crons_data.%[1]s WHERE timestamp < $1 LIMIT %[2]d)",
+		cron.Slug,
+		retentionBatchSize,
+	)
+
+	var total int64
+	for {
+		res, err := db.Exec(query, before)
+		if err != nil {
+			return total, err
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		if deleted < retentionBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// ApplyRetention deletes the samples older than the retention of their cron
+func ApplyRetention(db *Database) error {
+	crons, err := GetCrons(db, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error getting crons")
+	}
+
+	for _, cron := range crons {
+		retention := cron.Retention(db.Settings)
+		if retention <= 0 {
+			continue
+		}
+
+		before := time.Now().Add(-retention)
+		if cron.Hypertable {
+			dropped, err := dropChunksBefore(db, cron, before)
+			if err != nil {
+				slog.Error("Error dropping expired chunks", slog.Int64("id", cron.CronId), slog.Any("error", err))
+			} else if dropped > 0 {
+				slog.Info("Dropped expired cron chunks", slog.Int64("id", cron.CronId), slog.Int("chunks", dropped))
+			}
+			continue
+		}
+		if cron.Partitioning != nil {
+			// Partitioned tables only lose whole partitions, rows are kept until their partition expires
+			dropped, err := dropPartitionsBefore(db, cron, before)
+			if err != nil {
+				slog.Error("Error dropping expired partitions", slog.Int64("id", cron.CronId), slog.Any("error", err))
+			} else if dropped > 0 {
+				slog.Info("Dropped expired cron partitions", slog.Int64("id", cron.CronId), slog.Int("partitions", dropped))
+			}
+			continue
+		}
+
+		deleted, err := deleteCronDataBefore(db, cron, before)
+		if err != nil {
+			slog.Error("Error applying retention", slog.Int64("id", cron.CronId), slog.Any("error", err))
+			continue
+		}
+		if deleted > 0 {
+			slog.Info("Deleted expired cron data", slog.Int64("id", cron.CronId), slog.Int64("rows", deleted))
+		}
+	}
+
+	return nil
+}
+
+func GetCronStats(db *Database, slug string) (*CronStats, error) {
+	var stats CronStats
+	query := fmt.Sprintf("SELECT COUNT(*), MIN(timestamp), MAX(timestamp) FROM crons_data.%s", slug)
+	if err := db.QueryRow(query).Scan(&stats.RowCount, &stats.OldestSample, &stats.NewestSample); err != nil {
+		return nil, errors.Wrap(err, "Error getting cron stats")
+	}
+	return &stats, nil
+}
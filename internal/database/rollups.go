@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Minimum number of points a rollup must give over a time range to be picked
+const rollupMinPoints = 100
+
+func createRollupTable(db *Database, cron Cron, outputs []CronOutput, rollup CronRollup) error {
+	if cron.Hypertable {
+		if err := createContinuousAggregate(db, cron, outputs, rollup); err != nil {
+			return err
+		}
+		_, err := db.Exec("INSERT INTO cron_rollups (cron_id, resolution) VALUES ($1, $2);", cron.CronId, rollup.Resolution)
+		return err
+	}
+
+	labels, metrics := splitOutputs(cron, outputs)
+
+	tableQuery := fmt.Sprintf(`CREATE TABLE crons_data.%s (
+		bucket TIMESTAMPTZ NOT NULL,
+		sample_count BIGINT NOT NULL`, rollup.TableName(cron))
+	for _, label := range labels {
+		labelType := "TEXT"
+		if label == "connection_id" {
+			labelType = "INTEGER"
+		}
+		tableQuery += fmt.Sprintf(",%s %s", label, labelType)
+	}
+	for _, metric := range metrics {
+		tableQuery += fmt.Sprintf(",%[1]s_avg DOUBLE PRECISION,%[1]s_min %[2]s,%[1]s_max %[2]s,%[1]s_last %[2]s", metric.Name, metric.Type)
+	}
+	tableQuery += ");"
+	indexQuery := fmt.Sprintf(
+		"CREATE INDEX cron_%d_%s_bucket ON crons_data.%s(bucket);",
+		cron.CronId,
+		rollup.Resolution,
+		rollup.TableName(cron),
+	)
+	slog.Debug("Rollup table creation", slog.String("tableQuery", tableQuery), slog.String("indexQuery", indexQuery))
+
+	if _, err := db.Exec(tableQuery); err != nil {
+		return err
+	}
+	if _, err := db.Exec(indexQuery); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("INSERT INTO cron_rollups (cron_id, resolution) VALUES ($1, $2);", cron.CronId, rollup.Resolution)
+	return err
+}
+
+func validateRollups(resolutions []string) error {
+	for _, resolution := range resolutions {
+		if _, ok := Resolutions[resolution]; !ok {
+			return fmt.Errorf("unknown rollup resolution %s", resolution)
+		}
+	}
+	return nil
+}
+
+// addCronRollups creates the companion tables of a new cron
+func addCronRollups(db *Database, cron Cron, outputs []CronOutput, resolutions []string) error {
+	for _, resolution := range resolutions {
+		rollup := CronRollup{CronId: cron.CronId, Resolution: resolution}
+		if err := createRollupTable(db, cron, outputs, rollup); err != nil {
+			return errors.Wrapf(err, "Error creating %s rollup", resolution)
+		}
+	}
+	return nil
+}
+
+func GetCronRollups(db *Database, cronId int64) ([]CronRollup, error) {
+	var rollups []CronRollup
+	rows, err := db.Query("SELECT * FROM cron_rollups WHERE cron_id = $1", cronId)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting cron rollups")
+	}
+	if err := rows.Bind(&rollups); err != nil {
+		return nil, errors.Wrap(err, "Error binding cron rollups")
+	}
+	return rollups, nil
+}
+
+// updateRollup aggregates the buckets closed since the last update
+func updateRollup(db *Database, cron Cron, outputs []CronOutput, rollup CronRollup) error {
+	labels, metrics := splitOutputs(cron, outputs)
+	table := rollup.TableName(cron)
+
+	var from time.Time
+	if rollup.LastRolledUpAt != nil {
+		from = *rollup.LastRolledUpAt
+	}
+	var to time.Time
+	if err := db.QueryRow("SELECT date_trunc($1, NOW())", rollup.Resolution).Scan(&to); err != nil {
+		return errors.Wrap(err, "Error getting bucket boundary")
+	}
+	if !to.After(from) {
+		return nil
+	}
+
+	columns := "bucket,sample_count"
+	selects := fmt.Sprintf("date_trunc('%s', timestamp) AS bucket,COUNT(*)", rollup.Resolution)
+	groupBy := "bucket"
+	for _, label := range labels {
+		columns += "," + label
+		selects += "," + label
+		groupBy += "," + label
+	}
+	for _, metric := range metrics {
+		columns += fmt.Sprintf(",%[1]s_avg,%[1]s_min,%[1]s_max,%[1]s_last", metric.Name)
+		selects += fmt.Sprintf(",AVG(%[1]s),MIN(%[1]s),MAX(%[1]s),(array_agg(%[1]s ORDER BY timestamp DESC))[1]", metric.Name)
+	}
+
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM crons_data.%s WHERE bucket >= $1 AND bucket < $2", table), from, to); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error deleting stale buckets")
+	}
+	insertQuery := fmt.Sprintf(
+		"INSERT INTO crons_data.%s (%s) SELECT %s FROM crons_data.%s WHERE timestamp >= $1 AND timestamp < $2 GROUP BY %s",
+		table, columns, selects, cron.Slug, groupBy,
+	)
+	slog.Debug("Updating rollup", slog.String("query", insertQuery))
+	if _, err := tx.Exec(insertQuery, from, to); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error aggregating buckets")
+	}
+	if _, err := tx.Exec(
+		"UPDATE cron_rollups SET last_rolled_up_at = $1 WHERE cron_id = $2 AND resolution = $3",
+		to, cron.CronId, rollup.Resolution,
+	); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "Error saving rollup progress")
+	}
+
+	return tx.Commit()
+}
+
+// UpdateRollups aggregates the new samples of every cron into their rollups
+func UpdateRollups(db *Database) error {
+	crons, err := GetCrons(db, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error getting crons")
+	}
+
+	for _, cron := range crons {
+		rollups, err := GetCronRollups(db, cron.CronId)
+		if err != nil {
+			slog.Error("Error getting cron rollups", slog.Int64("id", cron.CronId), slog.Any("error", err))
+			continue
+		}
+		// Continuous aggregates are refreshed by TimescaleDB
+		if len(rollups) == 0 || cron.Hypertable {
+			continue
+		}
+		outputs, err := GetCronOutputs(db, cron.CronId)
+		if err != nil {
+			slog.Error("Error getting cron outputs", slog.Int64("id", cron.CronId), slog.Any("error", err))
+			continue
+		}
+
+		for _, rollup := range rollups {
+			if err := updateRollup(db, cron, outputs, rollup); err != nil {
+				slog.Error("Error updating rollup", slog.Int64("id", cron.CronId), slog.String("resolution", rollup.Resolution), slog.Any("error", err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// ChooseResolution picks the coarsest rollup still giving enough points over the range,
+// "raw" when none of them do
+func ChooseResolution(rollups []CronRollup, from time.Time, to time.Time) string {
+	resolution := "raw"
+	var best time.Duration
+	for _, rollup := range rollups {
+		length := Resolutions[rollup.Resolution]
+		if length <= 0 || to.Sub(from)/length < rollupMinPoints {
+			continue
+		}
+		if length > best {
+			best = length
+			resolution = rollup.Resolution
+		}
+	}
+	return resolution
+}
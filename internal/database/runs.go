@@ -0,0 +1,53 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Runs older than this are pruned from the run log
+const runLogRetention = "30 days"
+
+func saveCronRun(db *Database, cron Cron, connectionId int64, startedAt time.Time, rowCount int64, runErr error) error {
+	var message *string
+	if runErr != nil {
+		msg := runErr.Error()
+		message = &msg
+	}
+	_, err := db.Exec(
+		"INSERT INTO cron_runs (cron_id, connection_id, started_at, row_count, error) VALUES ($1, $2, $3, $4, $5)",
+		cron.CronId, connectionId, startedAt, rowCount, message,
+	)
+	if err != nil {
+		return errors.Wrap(err, "Error saving cron run")
+	}
+	return nil
+}
+
+// GetCronRuns lists the latest runs of a cron
+func GetCronRuns(db *Database, cronId int64, limit int) ([]CronRun, error) {
+	var runs []CronRun
+	rows, err := db.Query("SELECT * FROM cron_runs WHERE cron_id = $1 ORDER BY started_at DESC, run_id DESC LIMIT $2", cronId, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting cron runs")
+	}
+	if err := rows.Bind(&runs); err != nil {
+		return nil, errors.Wrap(err, "Error binding cron runs")
+	}
+	return runs, nil
+}
+
+// PruneCronRuns drops the old entries of the run log
+func PruneCronRuns(db *Database) error {
+	res, err := db.Exec(fmt.Sprintf("DELETE FROM cron_runs WHERE started_at < NOW() - INTERVAL '%s'", runLogRetention))
+	if err != nil {
+		return errors.Wrap(err, "Error pruning cron runs")
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		slog.Info("Pruned cron runs", slog.Int64("count", n))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"time"
+)
+
+// SampleBatch holds the rows saved by a cron run on one connection
+type SampleBatch struct {
+	Cron         Cron
+	ConnectionId int64
+	// Slot time of the run, shared by all its rows
+	Time    time.Time
+	Labels  []string
+	Metrics []CronOutput
+	Rows    []Object
+}
+
+// SampleObserver is given the rows of every run once they are committed,
+// it is called from the cron executor and must not block
+type SampleObserver func(batch SampleBatch)
+
+func (db *Database) AddObserver(observer SampleObserver) {
+	db.observers = append(db.observers, observer)
+}
+
+func (db *Database) observed() bool {
+	return len(db.observers) > 0
+}
+
+func (db *Database) notify(batch SampleBatch) {
+	for _, observer := range db.observers {
+		observer(batch)
+	}
+}
+
+// NumericValue converts a saved metric value, false when it is NULL
+func NumericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
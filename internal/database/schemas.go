@@ -1,12 +1,51 @@
 package database
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+// Tags maps a TEXT[] column, the pgx stdlib driver returns arrays in their text format
+type Tags []string
+
+func (t *Tags) Scan(src interface{}) error {
+	return pgtype.NewMap().SQLScanner((*[]string)(t)).Scan(src)
+}
+
+// Clean trims the tags and drops the empty or duplicated ones
+func (t Tags) Clean() Tags {
+	tags := Tags{}
+	seen := make(map[string]bool)
+	for _, tag := range t {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func (t Tags) Has(tag string) bool {
+	for _, tt := range t {
+		if tt == tag {
+			return true
+		}
+	}
+	return false
+}
+
 type ConnectionCreate struct {
 	DbType        string
 	ConnectionUrl string
+	Name          string
+	Description   string
+	Tags          Tags
 }
 
 type Connection struct {
@@ -18,6 +57,9 @@ type Connection struct {
 	Connected       bool
 	LastConnectedAt *time.Time
 	LastError       *string
+	Name            string
+	Description     string
+	Tags            Tags
 }
 
 type Table struct {
@@ -40,6 +82,26 @@ type CronCreate struct {
 	Name         string
 	Command      string
 	Schedule     string
+
+	// Running the cron against several connections stores their results
+	// in the same table, labelled with a connection_id column
+	ConnectionIds []int64
+	// Fan-out to every connection having this tag
+	ConnectionTag string
+
+	// Caps on the results of a run, 0 uses the global ones
+	MaxRows  int64
+	MaxBytes int64
+	// Days of data to keep, 0 uses the global retention
+	RetentionDays int64
+	// Resolutions of the aggregated companion tables, see Resolutions
+	Rollups []string
+	// Range partition the data table by week or month, empty for a plain table
+	Partitioning string
+}
+
+func (c *CronCreate) FanOut() bool {
+	return len(c.ConnectionIds) > 0 || c.ConnectionTag != ""
 }
 
 func (c *CronCreate) TableName() string {
@@ -68,6 +130,15 @@ type Cron struct {
 	Name         string
 	Command      string
 	Schedule     string
+	FanOut       bool
+	// Set when the fan-out targets are selected by tag
+	ConnectionTag *string
+	MaxRows       *int64
+	MaxBytes      *int64
+	LastError     *string
+	RetentionDays *int64
+	Partitioning  *string
+	Hypertable    bool
 
 	CronId    int64
 	Slug      string
@@ -76,26 +147,77 @@ type Cron struct {
 	LastRunAt *time.Time
 }
 
-func (c *Cron) NeedsToRun() bool {
-	if c.LastRunAt == nil {
-		return true
-	}
+// Interval is the time between two runs of the cron, 0 for unknown schedules
+func (c *Cron) Interval() time.Duration {
 	switch c.Schedule {
 	case "minute":
-		return c.LastRunAt.Add(1 * time.Minute).Before(time.Now())
+		return time.Minute
 	case "hour":
-		return c.LastRunAt.Add(1 * time.Hour).Before(time.Now())
+		return time.Hour
 	case "day":
-		return c.LastRunAt.Add(24 * time.Hour).Before(time.Now())
+		return 24 * time.Hour
 	case "week":
-		return c.LastRunAt.Add(7 * 24 * time.Hour).Before(time.Now())
+		return 7 * 24 * time.Hour
 	case "month":
-		return c.LastRunAt.Add(30 * 24 * time.Hour).Before(time.Now())
+		return 30 * 24 * time.Hour
 	case "year":
-		return c.LastRunAt.Add(365 * 24 * time.Hour).Before(time.Now())
+		return 365 * 24 * time.Hour
 	default:
+		return 0
+	}
+}
+
+func (c *Cron) NeedsToRun() bool {
+	if c.LastRunAt == nil {
+		return true
+	}
+	interval := c.Interval()
+	if interval == 0 {
 		return false
 	}
+	return c.LastRunAt.Add(interval).Before(time.Now())
+}
+
+// minLimit returns the tightest of two limits, 0 meaning no limit
+func minLimit(a int64, b *int64) int64 {
+	if b == nil || *b <= 0 {
+		return a
+	}
+	if a <= 0 || *b < a {
+		return *b
+	}
+	return a
+}
+
+// Limits returns the row and byte caps of a run, a cron can't go over the global ones
+func (c *Cron) Limits(settings Settings) (int64, int64) {
+	return minLimit(settings.MaxRows, c.MaxRows), minLimit(settings.MaxBytes, c.MaxBytes)
+}
+
+// Retention returns how long the data of the cron is kept, 0 meaning forever
+func (c *Cron) Retention(settings Settings) time.Duration {
+	days := settings.RetentionDays
+	if c.RetentionDays != nil {
+		days = *c.RetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+type CronStats struct {
+	RowCount     int64
+	OldestSample *time.Time
+	NewestSample *time.Time
+}
+
+// CronRun is the execution of a cron on one of its connections
+type CronRun struct {
+	RunId        int64
+	CronId       int64
+	ConnectionId int64
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	RowCount     int64
+	Error        *string
 }
 
 type CronOutput struct {
@@ -104,4 +226,355 @@ type CronOutput struct {
 	Type   string
 }
 
+// ColumnName is the name of a column of the data tables, they are created unquoted
+// so Postgres folded their names to lower case
+func ColumnName(name string) string {
+	return strings.ToLower(name)
+}
+
+// Column is the data table column of the output, the key of its values in saved rows
+func (o *CronOutput) Column() string {
+	return ColumnName(o.Name)
+}
+
+func (o *CronOutput) IsNumeric() bool {
+	return o.Type == "INTEGER" || o.Type == "REAL"
+}
+
+// Labels are the columns telling the series of a cron apart
+func (c *Cron) Labels(outputs []CronOutput) []string {
+	labels, _ := splitOutputs(*c, outputs)
+	return labels
+}
+
+// splitOutputs separates the label columns from the metric ones,
+// fan-out crons are labelled by their connection_id
+func splitOutputs(cron Cron, outputs []CronOutput) ([]string, []CronOutput) {
+	var labels []string
+	var metrics []CronOutput
+	if cron.FanOut {
+		labels = append(labels, "connection_id")
+	}
+	for _, output := range outputs {
+		if output.IsNumeric() {
+			metrics = append(metrics, output)
+		} else {
+			labels = append(labels, output.Name)
+		}
+	}
+	return labels, metrics
+}
+
+// Rollup resolutions and the length of their buckets
+var Resolutions = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+type CronRollup struct {
+	CronId         int64
+	Resolution     string
+	LastRolledUpAt *time.Time
+}
+
+func (r *CronRollup) TableName(cron Cron) string {
+	return cron.Slug + "__" + r.Resolution
+}
+
 type CronData map[string]interface{}
+
+type WebhookCreate struct {
+	// Global when 0
+	CronId int64
+	Url    string
+	// Generated when empty
+	Secret string
+	Events Tags
+}
+
+type Webhook struct {
+	WebhookId int64
+	CronId    *int64
+	Url       string
+	Secret    string
+	Events    Tags
+	CreatedAt time.Time
+	DeletedAt *time.Time
+}
+
+// Accepts tells whether the webhook subscribed to an event
+func (w *Webhook) Accepts(event Event) bool {
+	if w.CronId != nil && (event.CronId == nil || *event.CronId != *w.CronId) {
+		return false
+	}
+	return len(w.Events) == 0 || w.Events.Has(event.Type)
+}
+
+type WebhookDelivery struct {
+	DeliveryId     int64
+	WebhookId      int64
+	Event          string
+	Payload        string
+	Status         string
+	Attempts       int64
+	NextAttemptAt  time.Time
+	ResponseStatus *int64
+	LastError      *string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+}
+
+const (
+	ConditionAbove      = "above"
+	ConditionBelow      = "below"
+	ConditionChangeRate = "change_rate"
+	ConditionAbsentFor  = "absent_for"
+	// The anomaly score of the latest sample, from the detector of the column or a default z-score
+	ConditionAnomaly = "anomaly"
+)
+
+var Conditions = []string{ConditionAbove, ConditionBelow, ConditionChangeRate, ConditionAbsentFor, ConditionAnomaly}
+
+// LabelSet maps a JSON object of label values
+type LabelSet map[string]string
+
+func (l *LabelSet) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*l = LabelSet{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	default:
+		return fmt.Errorf("cannot scan %T into labels", src)
+	}
+}
+
+// Key identifies the series, encoding/json sorts the map keys
+func (l LabelSet) Key() string {
+	b, _ := json.Marshal(l)
+	return string(b)
+}
+
+type AlertRuleCreate struct {
+	CronId      int64
+	Name        string
+	ColumnName  string
+	LabelFilter LabelSet
+	Condition   string
+	Threshold   float64
+	// How long the condition must hold before firing, in seconds
+	ForSeconds int64
+}
+
+type AlertRule struct {
+	RuleId      int64
+	CronId      int64
+	Name        string
+	ColumnName  string
+	LabelFilter LabelSet
+	Condition   string
+	Threshold   float64
+	ForSeconds  int64
+	CreatedAt   time.Time
+	DeletedAt   *time.Time
+}
+
+func (r *AlertRule) For() time.Duration {
+	return time.Duration(r.ForSeconds) * time.Second
+}
+
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+type Alert struct {
+	AlertId    int64
+	RuleId     int64
+	Series     LabelSet
+	State      string
+	Value      *float64
+	StartedAt  time.Time
+	FiredAt    *time.Time
+	ResolvedAt *time.Time
+	UpdatedAt  time.Time
+}
+
+const (
+	ChannelEmail = "email"
+	ChannelSlack = "slack"
+)
+
+var ChannelKinds = []string{ChannelEmail, ChannelSlack}
+
+// Events notified by channels not picking their own
+var DefaultChannelEvents = Tags{EventRunFailed, EventSchemaChanged, EventConnectionDown, EventAlertFiring, EventAlertResolved, EventAnomaly}
+
+type NotificationChannelCreate struct {
+	Name   string
+	Kind   string
+	Target string
+	Events Tags
+	// Every cron when 0
+	CronId        int64
+	ConnectionTag string
+	Template      string
+	// Identical notifications are skipped for this long, in seconds
+	ThrottleSeconds int64
+}
+
+type NotificationChannel struct {
+	ChannelId       int64
+	Name            string
+	Kind            string
+	Target          string
+	Events          Tags
+	CronId          *int64
+	ConnectionTag   *string
+	Template        string
+	ThrottleSeconds int64
+	CreatedAt       time.Time
+	DeletedAt       *time.Time
+}
+
+// Subscribed tells whether the channel notifies this type of event
+func (c *NotificationChannel) Subscribed(eventType string) bool {
+	if len(c.Events) == 0 {
+		return DefaultChannelEvents.Has(eventType)
+	}
+	return c.Events.Has(eventType)
+}
+
+const (
+	NotificationSent      = "sent"
+	NotificationThrottled = "throttled"
+	NotificationFailed    = "failed"
+)
+
+type Notification struct {
+	NotificationId int64
+	ChannelId      int64
+	Event          string
+	ThrottleKey    string
+	Status         string
+	Message        string
+	Error          *string
+	CreatedAt      time.Time
+}
+
+const (
+	DetectorZScore   = "zscore"
+	DetectorSeasonal = "seasonal"
+)
+
+var DetectorMethods = []string{DetectorZScore, DetectorSeasonal}
+
+// Seasons of the seasonal detector
+var Seasons = map[string]time.Duration{
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+type AnomalyDetectorCreate struct {
+	CronId     int64
+	ColumnName string
+	Method     string
+	// Score above which a sample is anomalous, 3 when 0
+	Threshold float64
+	// Previous samples of a rolling z-score, or previous seasons of a seasonal baseline
+	WindowSize int64
+	// Only for the seasonal method
+	Season string
+}
+
+type AnomalyDetector struct {
+	DetectorId int64
+	CronId     int64
+	ColumnName string
+	Method     string
+	Threshold  float64
+	WindowSize int64
+	Season     *string
+	CreatedAt  time.Time
+	DeletedAt  *time.Time
+}
+
+type AnnotationInput struct {
+	// Scope of the annotation, global when both are 0
+	CronId       int64
+	ConnectionId int64
+	Time         time.Time
+	// Set for time ranges
+	TimeEnd *time.Time
+	Text    string
+	Tags    Tags
+}
+
+type Annotation struct {
+	AnnotationId int64
+	CronId       *int64
+	ConnectionId *int64
+	Time         time.Time
+	TimeEnd      *time.Time
+	Text         string
+	Tags         Tags
+	CreatedAt    time.Time
+}
+
+// AnnotationQuery selects the annotations overlapping a time range
+type AnnotationQuery struct {
+	From time.Time
+	To   time.Time
+	// Annotations of the cron, of its connections and global ones
+	CronId *int64
+	// Annotations having all of these tags
+	Tags  Tags
+	Limit int
+}
+
+const (
+	TokenPersonal = "personal"
+	TokenService  = "service"
+)
+
+var TokenKinds = []string{TokenPersonal, TokenService}
+
+const (
+	ScopeReadData          = "read:data"
+	ScopeManageCrons       = "manage:crons"
+	ScopeManageConnections = "manage:connections"
+)
+
+var TokenScopes = []string{ScopeReadData, ScopeManageCrons, ScopeManageConnections}
+
+type APITokenCreate struct {
+	Name   string
+	Kind   string
+	Scopes Tags
+	// Never expires when 0
+	ExpiresInDays int64
+}
+
+type APIToken struct {
+	TokenId   int64
+	Name      string
+	Kind      string
+	TokenHash string `json:"-"`
+	// Start of the token, to recognize it
+	Prefix     string
+	Scopes     Tags
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+	RevokedAt  *time.Time
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	return t.Scopes.Has(scope)
+}
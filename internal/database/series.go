@@ -0,0 +1,106 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type seriesSample struct {
+	time  time.Time
+	value *float64
+}
+
+// seriesData holds the samples of one series of a column, oldest first
+type seriesData struct {
+	labels  LabelSet
+	samples []seriesSample
+}
+
+func (s *seriesData) last() seriesSample {
+	return s.samples[len(s.samples)-1]
+}
+
+// labelFilterWhere turns a label filter into conditions, only known columns end up in
+// the query and the values are arguments
+func labelFilterWhere(labels []string, filter LabelSet, args []interface{}) ([]string, []interface{}, error) {
+	var where []string
+	for label, value := range filter {
+		if !slices.Contains(labels, label) {
+			return nil, nil, fmt.Errorf("unknown label %s", label)
+		}
+		args = append(args, value)
+		where = append(where, fmt.Sprintf("%s::TEXT = $%d", label, len(args)))
+	}
+	return where, args, nil
+}
+
+// labelSelects lists the label columns as text, followed by the extra expressions
+func labelSelects(labels []string, extra ...string) []string {
+	selects := make([]string, 0, len(labels)+len(extra))
+	for _, label := range labels {
+		selects = append(selects, label+"::TEXT")
+	}
+	return append(selects, extra...)
+}
+
+// readSeries groups the rows of a query selecting the labels, a timestamp and a value
+// by series, in the order they first show up
+func readSeries(db *Database, labels []string, query string, args ...interface{}) ([]seriesData, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading series")
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("Error closing rows", slog.Any("error", err))
+		}
+	}()
+
+	var series []seriesData
+	index := map[string]int{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(labels))
+		var ts sql.NullTime
+		var value sql.NullFloat64
+		dest := make([]interface{}, 0, len(labels)+2)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &ts, &value)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, errors.Wrap(err, "Error scanning series")
+		}
+		// MAX over no rows at all
+		if !ts.Valid {
+			continue
+		}
+
+		set := LabelSet{}
+		for i, label := range labels {
+			if values[i].Valid {
+				set[label] = values[i].String
+			}
+		}
+		key := set.Key()
+		i, ok := index[key]
+		if !ok {
+			i = len(series)
+			index[key] = i
+			series = append(series, seriesData{labels: set})
+		}
+		sample := seriesSample{time: ts.Time}
+		if value.Valid {
+			sample.value = &value.Float64
+		}
+		series[i].samples = append(series[i].samples, sample)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "Error reading series")
+	}
+	return series, nil
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Chunks older than this are compressed
+const timescaleCompressAfter = "7 days"
+
+func hasTimescale(db *Database) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(&exists)
+	return exists, err
+}
+
+// createHypertable converts a new cron table and compresses its older chunks,
+// segmenting them by label so that series can still be read separately
+func createHypertable(db *Database, cron Cron, outputs []CronOutput) error {
+	table := "crons_data." + cron.Slug
+
+	if _, err := db.Exec(fmt.Sprintf("SELECT create_hypertable('%s', 'timestamp');", table)); err != nil {
+		return errors.Wrap(err, "Error creating hypertable")
+	}
+
+	labels, _ := splitOutputs(cron, outputs)
+	compressQuery := fmt.Sprintf("ALTER TABLE %s SET (timescaledb.compress", table)
+	if len(labels) > 0 {
+		compressQuery += fmt.Sprintf(", timescaledb.compress_segmentby = '%s'", strings.Join(labels, ","))
+	}
+	compressQuery += ");"
+	if _, err := db.Exec(compressQuery); err != nil {
+		return errors.Wrap(err, "Error enabling compression")
+	}
+	if _, err := db.Exec(fmt.Sprintf("SELECT add_compression_policy('%s', INTERVAL '%s');", table, timescaleCompressAfter)); err != nil {
+		return errors.Wrap(err, "Error adding compression policy")
+	}
+	return nil
+}
+
+// createContinuousAggregate is the hypertable version of createRollupTable,
+// it exposes the same columns so that both can be read the same way
+func createContinuousAggregate(db *Database, cron Cron, outputs []CronOutput, rollup CronRollup) error {
+	labels, metrics := splitOutputs(cron, outputs)
+	view := "crons_data." + rollup.TableName(cron)
+
+	selects := fmt.Sprintf("time_bucket(INTERVAL '1 %s', timestamp) AS bucket,COUNT(*) AS sample_count", rollup.Resolution)
+	groupBy := "bucket"
+	for _, label := range labels {
+		selects += "," + label
+		groupBy += "," + label
+	}
+	for _, metric := range metrics {
+		selects += fmt.Sprintf(
+			",AVG(%[1]s) AS %[1]s_avg,MIN(%[1]s) AS %[1]s_min,MAX(%[1]s) AS %[1]s_max,last(%[1]s, timestamp) AS %[1]s_last",
+			metric.Name,
+		)
+	}
+
+	viewQuery := fmt.Sprintf(
+		"CREATE MATERIALIZED VIEW %s WITH (timescaledb.continuous) AS SELECT %s FROM crons_data.%s GROUP BY %s WITH NO DATA;",
+		view, selects, cron.Slug, groupBy,
+	)
+	// The refresh window must span at least two buckets, the current one is left open
+	policyQuery := fmt.Sprintf(
+		"SELECT add_continuous_aggregate_policy('%s', start_offset => INTERVAL '3 %[2]s', end_offset => INTERVAL '1 %[2]s', schedule_interval => INTERVAL '1 %[2]s');",
+		view, rollup.Resolution,
+	)
+	slog.Debug("Continuous aggregate creation", slog.String("viewQuery", viewQuery), slog.String("policyQuery", policyQuery))
+
+	if _, err := db.Exec(viewQuery); err != nil {
+		return err
+	}
+	if _, err := db.Exec(policyQuery); err != nil {
+		return err
+	}
+	return nil
+}
+
+// dropChunksBefore is the hypertable version of deleteCronDataBefore
+func dropChunksBefore(db *Database, cron Cron, before time.Time) (int, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT drop_chunks('crons_data.%s', older_than => $1::timestamptz)", cron.Slug), before)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("Error closing rows", slog.Any("error", err))
+		}
+	}()
+
+	dropped := 0
+	for rows.Next() {
+		dropped++
+	}
+	return dropped, rows.Err()
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Tokens are this prefix followed by 32 random bytes in hex
+const tokenPrefix = "grg_"
+
+// Characters of a token kept in clear to recognize it
+const tokenPrefixLength = len(tokenPrefix) + 8
+
+var ErrInvalidAPIToken = fmt.Errorf("invalid api token")
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AddAPIToken creates a token and returns it with its secret, which is not stored
+func AddAPIToken(db *Database, input APITokenCreate) (*APIToken, string, error) {
+	if strings.TrimSpace(input.Name) == "" {
+		return nil, "", fmt.Errorf("name is required")
+	}
+	if !slices.Contains(TokenKinds, input.Kind) {
+		return nil, "", fmt.Errorf("unknown token kind %s", input.Kind)
+	}
+	scopes := input.Scopes.Clean()
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(TokenScopes, scope) {
+			return nil, "", fmt.Errorf("unknown scope %s", scope)
+		}
+	}
+	if input.ExpiresInDays < 0 {
+		return nil, "", fmt.Errorf("expiry must not be negative")
+	}
+	var expiresAt *time.Time
+	if input.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, int(input.ExpiresInDays))
+		expiresAt = &t
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", errors.Wrap(err, "Error generating token")
+	}
+	secret := tokenPrefix + hex.EncodeToString(random)
+
+	var id int64
+	row := db.QueryRow(
+		"INSERT INTO api_tokens (name, kind, token_hash, prefix, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING token_id",
+		input.Name, input.Kind, hashToken(secret), secret[:tokenPrefixLength], []string(scopes), expiresAt,
+	)
+	if err := row.Scan(&id); err != nil {
+		return nil, "", errors.Wrap(err, "Error adding api token")
+	}
+	token, err := GetAPIToken(db, id)
+	if err != nil {
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+func GetAPIToken(db *Database, id int64) (*APIToken, error) {
+	var token APIToken
+	row := db.QueryRow("SELECT * FROM api_tokens WHERE token_id = $1", id)
+	if err := row.Bind(&token); err != nil {
+		return nil, errors.Wrap(err, "Error binding api token")
+	}
+	if token.TokenId == 0 {
+		return nil, fmt.Errorf("api token %d not found", id)
+	}
+	return &token, nil
+}
+
+// GetAPITokens lists the tokens, revoked ones included
+func GetAPITokens(db *Database) ([]APIToken, error) {
+	var tokens []APIToken
+	rows, err := db.Query("SELECT * FROM api_tokens ORDER BY token_id")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting api tokens")
+	}
+	if err := rows.Bind(&tokens); err != nil {
+		return nil, errors.Wrap(err, "Error binding api tokens")
+	}
+	return tokens, nil
+}
+
+func RevokeAPIToken(db *Database, id int64) error {
+	res, err := db.Exec("UPDATE api_tokens SET revoked_at = NOW() WHERE token_id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return errors.Wrap(err, "Error revoking api token")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("api token %d not found or already revoked", id)
+	}
+	return nil
+}
+
+// AuthenticateAPIToken returns the valid token matching a secret, and records its use
+func AuthenticateAPIToken(db *Database, secret string) (*APIToken, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	var token APIToken
+	row := db.QueryRow(
+		"UPDATE api_tokens SET last_used_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW()) RETURNING *",
+		hashToken(secret),
+	)
+	if err := row.Bind(&token); err != nil {
+		return nil, errors.Wrap(err, "Error authenticating api token")
+	}
+	if token.TokenId == 0 {
+		return nil, ErrInvalidAPIToken
+	}
+	return &token, nil
+}
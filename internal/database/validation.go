@@ -0,0 +1,154 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// InvalidInputError is an input rejected by validation, as opposed to a failure
+// of Grognon's database
+type InvalidInputError struct {
+	Err error
+}
+
+func (e *InvalidInputError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidInputError) Unwrap() error {
+	return e.Err
+}
+
+func invalidInput(err error) error {
+	return &InvalidInputError{err}
+}
+
+// Keywords that could modify the source database, even when nested in a WITH query
+var forbiddenKeywords = map[string]bool{
+	"ALTER":    true,
+	"ATTACH":   true,
+	"COPY":     true,
+	"CREATE":   true,
+	"DELETE":   true,
+	"DETACH":   true,
+	"DROP":     true,
+	"GRANT":    true,
+	"INSERT":   true,
+	"INTO":     true,
+	"MERGE":    true,
+	"PRAGMA":   true,
+	"REVOKE":   true,
+	"TRUNCATE": true,
+	"UPDATE":   true,
+	"VACUUM":   true,
+}
+
+// sqlTokens returns the keywords and semicolons of a command,
+// skipping comments, string literals and quoted identifiers
+func sqlTokens(command string) ([]string, error) {
+	var tokens []string
+	runes := []rune(command)
+
+	// skipUntil moves i after the next occurrence of end
+	skipUntil := func(i int, end string) (int, error) {
+		n := len([]rune(end))
+		for j := i; j+n <= len(runes); j++ {
+			if string(runes[j:j+n]) == end {
+				return j + n, nil
+			}
+		}
+		return 0, fmt.Errorf("unterminated %s", end)
+	}
+
+	var err error
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			i, err = skipUntil(i, "\n")
+			if err != nil {
+				// A trailing comment doesn't need a new line
+				i = len(runes)
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			if i, err = skipUntil(i+2, "*/"); err != nil {
+				return nil, err
+			}
+		case r == '\'' || r == '"' || r == '`':
+			// Doubled quotes are escapes, skipping both halves works the same
+			if i, err = skipUntil(i+1, string(r)); err != nil {
+				return nil, err
+			}
+		case r == '[':
+			if i, err = skipUntil(i+1, "]"); err != nil {
+				return nil, err
+			}
+		case r == '$':
+			// Postgres dollar quoted strings, $tag$...$tag$
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			if j < len(runes) && runes[j] == '$' {
+				if i, err = skipUntil(j+1, string(runes[i:j+1])); err != nil {
+					return nil, err
+				}
+			} else {
+				i = j
+			}
+		case r == ';':
+			tokens = append(tokens, ";")
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '$') {
+				j++
+			}
+			tokens = append(tokens, strings.ToUpper(string(runes[i:j])))
+			i = j
+		default:
+			i++
+		}
+	}
+
+	return tokens, nil
+}
+
+// ValidateCommand only accepts a single SELECT or WITH query
+func ValidateCommand(command string) error {
+	tokens, err := sqlTokens(command)
+	if err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	if tokens[0] != "SELECT" && tokens[0] != "WITH" {
+		return fmt.Errorf("only SELECT and WITH queries are allowed, got %s", tokens[0])
+	}
+	for i, token := range tokens {
+		if token == ";" && i != len(tokens)-1 {
+			return fmt.Errorf("only a single statement is allowed")
+		}
+		if forbiddenKeywords[token] {
+			return fmt.Errorf("%s is not allowed in a cron command", token)
+		}
+	}
+
+	return nil
+}
+
+// validateOutputNames rejects the columns a cron table already holds, fan-out
+// crons tell their rows apart by a connection_id column
+func validateOutputNames(fanOut bool, cols []string) error {
+	for _, col := range cols {
+		if fanOut && ColumnName(col) == "connection_id" {
+			return invalidInput(fmt.Errorf("column %s is reserved for the connection of fan-out crons", col))
+		}
+	}
+	return nil
+}
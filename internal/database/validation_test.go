@@ -0,0 +1,52 @@
+package database
+
+import "testing"
+
+func TestValidateCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		valid   bool
+	}{
+		{"select", "SELECT name, load FROM hosts", true},
+		{"lower case", "select 1", true},
+		{"with", "WITH t AS (SELECT 1 AS n) SELECT n FROM t", true},
+		{"trailing semicolon", "SELECT 1;", true},
+		{"keyword in identifier", "SELECT updated_at, deleted FROM t", true},
+		{"keyword in string", "SELECT 'DROP TABLE t; DELETE FROM t'", true},
+		{"keyword in quoted identifier", `SELECT "delete" FROM t`, true},
+		{"keyword in line comment", "-- DROP TABLE t;\nSELECT 1", true},
+		{"trailing line comment", "SELECT 1 -- ; DELETE FROM t", true},
+		{"keyword in block comment", "/* DELETE FROM t; */ SELECT 1", true},
+		{"keyword in dollar quotes", "SELECT $$DELETE FROM t; DROP TABLE t$$", true},
+		{"keyword in tagged dollar quotes", "SELECT $q$ $$; DROP TABLE t $q$", true},
+		{"positional parameter", "SELECT * FROM t WHERE id = $1", true},
+
+		{"empty", "", false},
+		{"only a comment", "-- SELECT 1", false},
+		{"update", "UPDATE t SET x = 1", false},
+		{"explain", "EXPLAIN SELECT 1", false},
+		{"select into", "SELECT * INTO copy FROM t", false},
+		{"data modifying cte", "WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", false},
+		{"insert cte", "WITH i AS (INSERT INTO t VALUES (1) RETURNING *) SELECT * FROM i", false},
+		{"multiple statements", "SELECT 1; SELECT 2", false},
+		{"statement after semicolon", "SELECT 1; DROP TABLE t", false},
+		{"statement after comment", "SELECT 1 /* */; DELETE FROM t", false},
+		{"statement after string", "SELECT ';'; DELETE FROM t", false},
+		{"statement after dollar quotes", "SELECT $$;$$; DELETE FROM t", false},
+		{"unterminated string", "SELECT 'a", false},
+		{"unterminated block comment", "SELECT 1 /* DELETE", false},
+		{"unterminated dollar quotes", "SELECT $q$ a; DELETE FROM t", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCommand(tt.command)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateCommand(%q) = %v, want nil", tt.command, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("ValidateCommand(%q) = nil, want an error", tt.command)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	webhookMaxAttempts = 8
+	webhookRetryBase   = 30 * time.Second
+	webhookRetryMax    = time.Hour
+	// Deliveries are kept this long in the log
+	webhookLogRetention = "30 days"
+)
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func AddWebhook(db *Database, input WebhookCreate) (*Webhook, error) {
+	u, err := url.Parse(input.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url %s", input.Url)
+	}
+	events := input.Events.Clean()
+	for _, event := range events {
+		if !slices.Contains(EventTypes, event) {
+			return nil, fmt.Errorf("unknown event %s", event)
+		}
+	}
+	var cronId *int64
+	if input.CronId != 0 {
+		if _, err := GetCron(db, input.CronId); err != nil {
+			return nil, err
+		}
+		cronId = &input.CronId
+	}
+	secret := input.Secret
+	if secret == "" {
+		if secret, err = newWebhookSecret(); err != nil {
+			return nil, errors.Wrap(err, "Error generating webhook secret")
+		}
+	}
+
+	var id int64
+	row := db.QueryRow(
+		"INSERT INTO webhooks (cron_id, url, secret, events) VALUES ($1, $2, $3, $4) RETURNING webhook_id",
+		cronId, input.Url, secret, []string(events),
+	)
+	if err := row.Scan(&id); err != nil {
+		return nil, errors.Wrap(err, "Error adding webhook")
+	}
+	return GetWebhook(db, id)
+}
+
+func GetWebhook(db *Database, id int64) (*Webhook, error) {
+	var webhook Webhook
+	row := db.QueryRow("SELECT * FROM webhooks WHERE webhook_id = $1 AND deleted_at IS NULL", id)
+	if err := row.Bind(&webhook); err != nil {
+		return nil, errors.Wrap(err, "Error binding webhook")
+	}
+	if webhook.WebhookId == 0 {
+		return nil, fmt.Errorf("webhook %d not found", id)
+	}
+	return &webhook, nil
+}
+
+// GetWebhooks lists every webhook, or the ones of a cron
+func GetWebhooks(db *Database, cronId *int64) ([]Webhook, error) {
+	var webhooks []Webhook
+	query := "SELECT * FROM webhooks WHERE deleted_at IS NULL"
+	var args []interface{}
+	if cronId != nil {
+		query += " AND cron_id = $1"
+		args = append(args, *cronId)
+	}
+	query += " ORDER BY webhook_id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting webhooks")
+	}
+	if err := rows.Bind(&webhooks); err != nil {
+		return nil, errors.Wrap(err, "Error binding webhooks")
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook stops the webhook, its pending deliveries are dropped
+func DeleteWebhook(db *Database, id int64) error {
+	if _, err := db.Exec("UPDATE webhooks SET deleted_at = NOW() WHERE webhook_id = $1", id); err != nil {
+		return errors.Wrap(err, "Error deleting webhook")
+	}
+	_, err := db.Exec("UPDATE webhook_deliveries SET status = 'failed', last_error = 'Webhook deleted' WHERE webhook_id = $1 AND status = 'pending'", id)
+	return err
+}
+
+// QueueWebhookDeliveries saves a delivery for every webhook subscribed to an event
+func QueueWebhookDeliveries(db *Database, event Event) error {
+	webhooks, err := GetWebhooks(db, nil)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "Error encoding event")
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Accepts(event) {
+			continue
+		}
+		if _, err := db.Exec(
+			"INSERT INTO webhook_deliveries (webhook_id, event, payload) VALUES ($1, $2, $3)",
+			webhook.WebhookId, event.Type, string(payload),
+		); err != nil {
+			return errors.Wrap(err, "Error queuing webhook delivery")
+		}
+	}
+	return nil
+}
+
+// GetDueDeliveries lists the pending deliveries whose next attempt has come
+func GetDueDeliveries(db *Database, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	rows, err := db.Query(
+		"SELECT * FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= NOW() ORDER BY delivery_id LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting due deliveries")
+	}
+	if err := rows.Bind(&deliveries); err != nil {
+		return nil, errors.Wrap(err, "Error binding deliveries")
+	}
+	return deliveries, nil
+}
+
+// SaveDeliveryAttempt records the outcome of an attempt, failed ones are retried with
+// an exponential backoff until they run out of attempts
+func SaveDeliveryAttempt(db *Database, delivery WebhookDelivery, responseStatus int, deliveryErr error) error {
+	var status *int
+	if responseStatus != 0 {
+		status = &responseStatus
+	}
+	attempts := delivery.Attempts + 1
+
+	if deliveryErr == nil {
+		_, err := db.Exec(
+			"UPDATE webhook_deliveries SET status = 'delivered', attempts = $1, response_status = $2, last_error = NULL, delivered_at = NOW() WHERE delivery_id = $3",
+			attempts, status, delivery.DeliveryId,
+		)
+		return err
+	}
+
+	nextStatus := "pending"
+	if attempts >= webhookMaxAttempts {
+		nextStatus = "failed"
+	}
+	backoff := min(webhookRetryBase<<(attempts-1), webhookRetryMax)
+	_, err := db.Exec(
+		"UPDATE webhook_deliveries SET status = $1, attempts = $2, response_status = $3, last_error = $4, next_attempt_at = $5 WHERE delivery_id = $6",
+		nextStatus, attempts, status, deliveryErr.Error(), time.Now().Add(backoff), delivery.DeliveryId,
+	)
+	return err
+}
+
+// GetWebhookDeliveries lists the latest deliveries of a webhook
+func GetWebhookDeliveries(db *Database, webhookId int64, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	rows, err := db.Query(
+		"SELECT * FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY delivery_id DESC LIMIT $2",
+		webhookId, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting webhook deliveries")
+	}
+	if err := rows.Bind(&deliveries); err != nil {
+		return nil, errors.Wrap(err, "Error binding webhook deliveries")
+	}
+	return deliveries, nil
+}
+
+// PruneWebhookDeliveries drops the old entries of the delivery log
+func PruneWebhookDeliveries(db *Database) error {
+	res, err := db.Exec(fmt.Sprintf(
+		"DELETE FROM webhook_deliveries WHERE status != 'pending' AND created_at < NOW() - INTERVAL '%s'",
+		webhookLogRetention,
+	))
+	if err != nil {
+		return errors.Wrap(err, "Error pruning webhook deliveries")
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		slog.Info("Pruned webhook deliveries", slog.Int64("count", n))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	// Email channels are disabled when empty
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// Refuse to send without upgrading the connection, only disable it for a local catcher
+	StartTLS bool
+}
+
+// sendMail delivers a plain text message to a comma separated list of recipients
+func sendMail(ctx context.Context, cfg SMTPConfig, recipients string, subject string, body string) error {
+	if cfg.Host == "" {
+		return fmt.Errorf("no SMTP server configured")
+	}
+	to, err := mail.ParseAddressList(recipients)
+	if err != nil {
+		return fmt.Errorf("invalid recipients: %w", err)
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if cfg.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		// PlainAuth refuses to send the password in clear, except to localhost
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	var headerTo []string
+	for _, addr := range to {
+		if err := c.Rcpt(addr.Address); err != nil {
+			return err
+		}
+		headerTo = append(headerTo, addr.String())
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + strings.Join(headerTo, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	// Bare line feeds are not allowed in SMTP bodies
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n") + "\r\n"
+	if _, err := w.Write([]byte(message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
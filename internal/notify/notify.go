@@ -0,0 +1,185 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"d34d.one/grognon/internal/database"
+)
+
+const (
+	// Events waiting to be notified, newer ones are dropped past this
+	queueSize = 256
+	timeout   = 30 * time.Second
+)
+
+// DefaultTemplate is used by channels without their own, the first line is the
+// subject of emails
+const DefaultTemplate = `[grognon] {{title .Type}}{{with .CronName}} on {{.}}{{end}}{{with .Data.rule}}: {{.}}{{end}}
+{{range $key, $value := .Data}}{{$key}}: {{$value}}
+{{end}}`
+
+var funcs = template.FuncMap{
+	// alert_firing becomes Alert firing
+	"title": func(s string) string {
+		s = strings.ReplaceAll(s, "_", " ")
+		if s == "" {
+			return s
+		}
+		return strings.ToUpper(s[:1]) + s[1:]
+	},
+}
+
+type Config struct {
+	SMTP SMTPConfig
+}
+
+// Notifier tells the channels about the events routed to them
+type Notifier struct {
+	db     *database.Database
+	cfg    Config
+	events chan database.Event
+}
+
+func New(db *database.Database, cfg Config) *Notifier {
+	return &Notifier{db, cfg, make(chan database.Event, queueSize)}
+}
+
+// Observe queues the event, sending happens in Run to keep the cron executor going
+func (n *Notifier) Observe(event database.Event) {
+	select {
+	case n.events <- event:
+	default:
+		slog.Warn("Notification queue full, dropping event", slog.String("event", event.Type))
+	}
+}
+
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.events:
+			channels, err := database.GetChannelsForEvent(n.db, event)
+			if err != nil {
+				slog.Error("Error routing event", slog.String("event", event.Type), slog.Any("error", err))
+				continue
+			}
+			for _, channel := range channels {
+				_ = n.notify(ctx, channel, event, true)
+			}
+		}
+	}
+}
+
+// Test sends a sample event to a channel, whatever its routing and throttle
+func (n *Notifier) Test(ctx context.Context, channel database.NotificationChannel) error {
+	event := database.Event{
+		Type: "test",
+		Time: time.Now(),
+		Data: map[string]interface{}{"message": "Test notification from grognon"},
+	}
+	return n.notify(ctx, channel, event, false)
+}
+
+// throttleKey tells identical notifications apart: same event on the same cron,
+// connection and alert series
+func throttleKey(event database.Event) string {
+	parts := []string{event.Type}
+	if event.CronId != nil {
+		parts = append(parts, fmt.Sprintf("cron=%d", *event.CronId))
+	}
+	if event.ConnectionId != nil {
+		parts = append(parts, fmt.Sprintf("connection=%d", *event.ConnectionId))
+	}
+	for _, key := range []string{"rule_id", "labels"} {
+		if value, ok := event.Data[key]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%v", key, value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (n *Notifier) notify(ctx context.Context, channel database.NotificationChannel, event database.Event, throttle bool) error {
+	notification := database.Notification{
+		ChannelId:   channel.ChannelId,
+		Event:       event.Type,
+		ThrottleKey: throttleKey(event),
+		Status:      database.NotificationSent,
+	}
+
+	var err error
+	if throttle {
+		throttled, err := database.IsThrottled(n.db, channel, notification.ThrottleKey)
+		if err != nil {
+			slog.Error("Error checking notification throttle", slog.Int64("channel_id", channel.ChannelId), slog.Any("error", err))
+		}
+		if throttled {
+			notification.Status = database.NotificationThrottled
+		}
+	}
+
+	if notification.Status == database.NotificationSent {
+		notification.Message, err = Render(channel.Template, event)
+		if err == nil {
+			err = n.send(ctx, channel, notification.Message)
+		}
+		if err != nil {
+			slog.Error("Error sending notification", slog.Int64("channel_id", channel.ChannelId), slog.String("event", event.Type), slog.Any("error", err))
+			notification.Status = database.NotificationFailed
+			message := err.Error()
+			notification.Error = &message
+		}
+	}
+
+	if saveErr := database.SaveNotification(n.db, notification); saveErr != nil {
+		slog.Error("Error saving notification", slog.Int64("channel_id", channel.ChannelId), slog.Any("error", saveErr))
+	}
+	return err
+}
+
+// Render executes the template of a channel on an event
+func Render(text string, event database.Event) (string, error) {
+	if strings.TrimSpace(text) == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New("message").Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, event); err != nil {
+		return "", fmt.Errorf("error rendering template: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func (n *Notifier) send(ctx context.Context, channel database.NotificationChannel, message string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch channel.Kind {
+	case database.ChannelEmail:
+		subject, body, _ := strings.Cut(message, "\n")
+		return sendMail(ctx, n.cfg.SMTP, channel.Target, subject, strings.TrimSpace(body))
+	case database.ChannelSlack:
+		return postSlack(ctx, channel.Target, message)
+	default:
+		return fmt.Errorf("unknown channel kind %s", channel.Kind)
+	}
+}
+
+// Kinds lists the channel kinds that can be sent with the current configuration
+func (n *Notifier) Kinds() []string {
+	kinds := slices.Clone(database.ChannelKinds)
+	if n.cfg.SMTP.Host == "" {
+		kinds = slices.DeleteFunc(kinds, func(kind string) bool { return kind == database.ChannelEmail })
+	}
+	return kinds
+}
@@ -0,0 +1,146 @@
+package influx
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"d34d.one/grognon/internal/database"
+	"d34d.one/grognon/internal/sinks"
+)
+
+const (
+	// Runs waiting to be written, newer ones are dropped past this
+	queueSize   = 256
+	maxAttempts = 5
+)
+
+type Config struct {
+	// Base URL of the InfluxDB server, lines are posted to <url>/api/v2/write
+	URL     string
+	Org     string
+	Bucket  string
+	Token   string
+	Timeout time.Duration
+}
+
+// Writer sends every run in the line protocol, the cron slug is the measurement,
+// label columns are tags and numeric columns are fields
+type Writer struct {
+	cfg     Config
+	client  *http.Client
+	url     string
+	headers map[string]string
+	queue   *sinks.Queue
+}
+
+func New(cfg Config) *Writer {
+	query := url.Values{}
+	query.Set("org", cfg.Org)
+	query.Set("bucket", cfg.Bucket)
+	query.Set("precision", "ns")
+
+	w := &Writer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		url:    strings.TrimSuffix(cfg.URL, "/") + "/api/v2/write?" + query.Encode(),
+		headers: map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		},
+	}
+	if cfg.Token != "" {
+		w.headers["Authorization"] = "Token " + cfg.Token
+	}
+	w.queue = sinks.NewQueue(w.Name(), queueSize, maxAttempts, w.send)
+	return w
+}
+
+func (w *Writer) Name() string { return "influxdb" }
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// fieldValue encodes a value after the declared type of its output, Influx rejects
+// a field written as an integer and as a float
+func fieldValue(output database.CronOutput, value interface{}) (string, bool) {
+	if output.Type == "INTEGER" {
+		switch v := value.(type) {
+		case int64:
+			return strconv.FormatInt(v, 10) + "i", true
+		case int32:
+			return strconv.FormatInt(int64(v), 10) + "i", true
+		}
+		// SQLite doesn't enforce column types, fractional values don't fit the field
+		f, ok := database.NumericValue(value)
+		if !ok || f != math.Trunc(f) {
+			return "", false
+		}
+		return strconv.FormatInt(int64(f), 10) + "i", true
+	}
+
+	f, ok := database.NumericValue(value)
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), true
+}
+
+// Lines encodes a run, one line per row with at least one field
+func Lines(batch database.SampleBatch) []byte {
+	var b strings.Builder
+	measurement := measurementEscaper.Replace(batch.Cron.Slug)
+	timestamp := strconv.FormatInt(batch.Time.UnixNano(), 10)
+
+	// InfluxDB prefers tags sorted by key
+	labels := slices.Clone(batch.Labels)
+	slices.Sort(labels)
+
+	for _, row := range batch.Rows {
+		var fields []string
+		for _, metric := range batch.Metrics {
+			if value, ok := fieldValue(metric, row[metric.Column()]); ok {
+				fields = append(fields, keyEscaper.Replace(metric.Name)+"="+value)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		b.WriteString(measurement)
+		for _, label := range labels {
+			// Empty tag values are not allowed
+			raw := row[database.ColumnName(label)]
+			value := fmt.Sprint(raw)
+			if raw == nil || value == "" {
+				continue
+			}
+			fmt.Fprintf(&b, ",%s=%s", keyEscaper.Replace(label), keyEscaper.Replace(value))
+		}
+		fmt.Fprintf(&b, " %s %s\n", strings.Join(fields, ","), timestamp)
+	}
+	return []byte(b.String())
+}
+
+func (w *Writer) Observe(batch database.SampleBatch) {
+	lines := Lines(batch)
+	if len(lines) == 0 {
+		return
+	}
+	w.queue.Push(lines)
+}
+
+func (w *Writer) send(ctx context.Context, lines []byte) error {
+	return sinks.Post(ctx, w.client, w.url, w.headers, lines)
+}
+
+func (w *Writer) Run(ctx context.Context) {
+	w.queue.Run(ctx)
+}
@@ -0,0 +1,186 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"d34d.one/grognon/internal/database"
+	"d34d.one/grognon/internal/sinks"
+)
+
+const (
+	// Runs waiting to be exported, newer ones are dropped past this
+	queueSize   = 256
+	maxAttempts = 5
+)
+
+type Config struct {
+	// Base URL of an OTLP/HTTP receiver, metrics are posted to <endpoint>/v1/metrics
+	Endpoint string
+	Headers  map[string]string
+	Timeout  time.Duration
+}
+
+// Exporter publishes the numeric outputs of every run as OTLP gauges,
+// encoded as OTLP/HTTP JSON
+type Exporter struct {
+	cfg     Config
+	client  *http.Client
+	headers map[string]string
+	queue   *sinks.Queue
+}
+
+func New(cfg Config) *Exporter {
+	e := &Exporter{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		headers: map[string]string{"Content-Type": "application/json"},
+	}
+	for key, value := range cfg.Headers {
+		e.headers[key] = value
+	}
+	e.queue = sinks.NewQueue(e.Name(), queueSize, maxAttempts, e.send)
+	return e
+}
+
+func (e *Exporter) Name() string { return "otlp" }
+
+// ParseHeaders reads key=value pairs
+func ParseHeaders(pairs []string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %s, expected key=value", pair)
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+func stringAttr(key string, value string) keyValue {
+	return keyValue{key, anyValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int64) keyValue {
+	v := strconv.FormatInt(value, 10)
+	return keyValue{key, anyValue{IntValue: &v}}
+}
+
+// 64 bit integers are strings in the JSON encoding of protobuf
+type dataPoint struct {
+	Attributes   []keyValue `json:"attributes"`
+	TimeUnixNano string     `json:"timeUnixNano"`
+	AsInt        *string    `json:"asInt,omitempty"`
+	AsDouble     *float64   `json:"asDouble,omitempty"`
+}
+
+type metric struct {
+	Name  string `json:"name"`
+	Gauge struct {
+		DataPoints []dataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+}
+
+type scopeMetrics struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type resourceMetrics struct {
+	Resource struct {
+		Attributes []keyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+// requestOf converts a run, the cron and connection describe the resource and
+// every numeric output is a gauge named <cron slug>.<column>
+func requestOf(batch database.SampleBatch) (exportRequest, int) {
+	var rm resourceMetrics
+	rm.Resource.Attributes = []keyValue{
+		stringAttr("service.name", "grognon"),
+		intAttr("grognon.cron.id", batch.Cron.CronId),
+		stringAttr("grognon.cron.name", batch.Cron.Name),
+		stringAttr("grognon.cron.slug", batch.Cron.Slug),
+		intAttr("grognon.connection.id", batch.ConnectionId),
+	}
+
+	timestamp := strconv.FormatInt(batch.Time.UnixNano(), 10)
+	points := 0
+	var sm scopeMetrics
+	sm.Scope.Name = "grognon"
+	for _, output := range batch.Metrics {
+		m := metric{Name: batch.Cron.Slug + "." + output.Name}
+		for _, row := range batch.Rows {
+			point := dataPoint{Attributes: []keyValue{}, TimeUnixNano: timestamp}
+			switch v := row[output.Column()].(type) {
+			case int64:
+				asInt := strconv.FormatInt(v, 10)
+				point.AsInt = &asInt
+			default:
+				value, ok := database.NumericValue(v)
+				if !ok {
+					continue
+				}
+				point.AsDouble = &value
+			}
+			for _, label := range batch.Labels {
+				if v := row[database.ColumnName(label)]; v != nil {
+					point.Attributes = append(point.Attributes, stringAttr(label, fmt.Sprint(v)))
+				}
+			}
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, point)
+		}
+		if len(m.Gauge.DataPoints) > 0 {
+			sm.Metrics = append(sm.Metrics, m)
+			points += len(m.Gauge.DataPoints)
+		}
+	}
+	rm.ScopeMetrics = []scopeMetrics{sm}
+	return exportRequest{[]resourceMetrics{rm}}, points
+}
+
+func (e *Exporter) Observe(batch database.SampleBatch) {
+	req, points := requestOf(batch)
+	if points == 0 {
+		return
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		slog.Error("Error encoding OTLP metrics", slog.Any("error", err))
+		return
+	}
+	e.queue.Push(body)
+}
+
+func (e *Exporter) send(ctx context.Context, body []byte) error {
+	url := strings.TrimSuffix(e.cfg.Endpoint, "/") + "/v1/metrics"
+	return sinks.Post(ctx, e.client, url, e.headers, body)
+}
+
+func (e *Exporter) Run(ctx context.Context) {
+	e.queue.Run(ctx)
+}
@@ -0,0 +1,69 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// Backoff doubles the wait after every failure, up to five minutes
+func Backoff(previous time.Duration) time.Duration {
+	return min(max(2*previous, minBackoff), maxBackoff)
+}
+
+// Queue delivers encoded payloads in memory and in order, for sinks that can
+// lose samples across restarts
+type Queue struct {
+	name     string
+	items    chan []byte
+	attempts int
+	send     func(ctx context.Context, payload []byte) error
+}
+
+func NewQueue(name string, size int, attempts int, send func(ctx context.Context, payload []byte) error) *Queue {
+	return &Queue{name, make(chan []byte, size), attempts, send}
+}
+
+// Push drops the payload when the queue is full rather than blocking
+func (q *Queue) Push(payload []byte) {
+	select {
+	case q.items <- payload:
+	default:
+		slog.Warn("Sink queue full, dropping samples", slog.String("sink", q.name))
+	}
+}
+
+// Run sends the payloads until the context is done, retryable failures are
+// attempted again with a backoff
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-q.items:
+			var backoff time.Duration
+			for attempt := 1; ; attempt++ {
+				err := q.send(ctx, payload)
+				if err == nil {
+					break
+				}
+				var retryable RetryableError
+				if !errors.As(err, &retryable) || attempt == q.attempts || ctx.Err() != nil {
+					slog.Error("Error sending samples, dropping them", slog.String("sink", q.name), slog.Int("attempts", attempt), slog.Any("error", err))
+					break
+				}
+				backoff = Backoff(backoff)
+				select {
+				case <-ctx.Done():
+				case <-time.After(backoff):
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package remotewrite
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+
+	"d34d.one/grognon/internal/database"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages of the remote write 1.0 protocol, encoded by hand to avoid
+// depending on the Prometheus module
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value float64
+	// Milliseconds since the epoch
+	Timestamp int64
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// seriesOf converts a run, every numeric output of every row is a series
+// named <cron slug>_<column> with the label columns as labels
+func seriesOf(batch database.SampleBatch) []TimeSeries {
+	var series []TimeSeries
+	for _, row := range batch.Rows {
+		var labels []Label
+		for _, label := range batch.Labels {
+			if v := row[database.ColumnName(label)]; v != nil {
+				labels = append(labels, Label{database.PromName(label), fmt.Sprint(v)})
+			}
+		}
+
+		for _, metric := range batch.Metrics {
+			value, ok := database.NumericValue(row[metric.Column()])
+			if !ok {
+				continue
+			}
+			seriesLabels := append([]Label{{"__name__", database.PromName(batch.Cron.Slug, metric.Name)}}, labels...)
+			// Receivers expect the labels sorted by name
+			slices.SortFunc(seriesLabels, func(a, b Label) int { return strings.Compare(a.Name, b.Name) })
+			series = append(series, TimeSeries{
+				Labels:  seriesLabels,
+				Samples: []Sample{{value, batch.Time.UnixMilli()}},
+			})
+		}
+	}
+	return series
+}
+
+// Encode builds a snappy compressed WriteRequest
+func Encode(series []TimeSeries) []byte {
+	var req []byte
+	for _, ts := range series {
+		var tsBytes []byte
+		for _, label := range ts.Labels {
+			var labelBytes []byte
+			labelBytes = protowire.AppendTag(labelBytes, 1, protowire.BytesType)
+			labelBytes = protowire.AppendString(labelBytes, label.Name)
+			labelBytes = protowire.AppendTag(labelBytes, 2, protowire.BytesType)
+			labelBytes = protowire.AppendString(labelBytes, label.Value)
+
+			tsBytes = protowire.AppendTag(tsBytes, 1, protowire.BytesType)
+			tsBytes = protowire.AppendBytes(tsBytes, labelBytes)
+		}
+		for _, sample := range ts.Samples {
+			var sampleBytes []byte
+			sampleBytes = protowire.AppendTag(sampleBytes, 1, protowire.Fixed64Type)
+			sampleBytes = protowire.AppendFixed64(sampleBytes, math.Float64bits(sample.Value))
+			sampleBytes = protowire.AppendTag(sampleBytes, 2, protowire.VarintType)
+			sampleBytes = protowire.AppendVarint(sampleBytes, uint64(sample.Timestamp))
+
+			tsBytes = protowire.AppendTag(tsBytes, 2, protowire.BytesType)
+			tsBytes = protowire.AppendBytes(tsBytes, sampleBytes)
+		}
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, tsBytes)
+	}
+	return snappy.Encode(nil, req)
+}
+
+// fields calls fn with every field of a message, skipping the ones it doesn't consume
+func fields(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// Decode reads a snappy compressed WriteRequest, for receivers used in development
+func Decode(body []byte) ([]TimeSeries, error) {
+	req, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var series []TimeSeries
+	err = fields(req, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 || typ != protowire.BytesType {
+			return 0, nil
+		}
+		tsBytes, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		var ts TimeSeries
+		err := fields(tsBytes, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+			if typ != protowire.BytesType {
+				return 0, nil
+			}
+			msg, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			switch num {
+			case 1:
+				var label Label
+				err := fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+					if typ != protowire.BytesType {
+						return 0, nil
+					}
+					v, n := protowire.ConsumeString(b)
+					if num == 1 {
+						label.Name = v
+					} else if num == 2 {
+						label.Value = v
+					}
+					return n, nil
+				})
+				ts.Labels = append(ts.Labels, label)
+				return n, err
+			case 2:
+				var sample Sample
+				err := fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+					switch {
+					case num == 1 && typ == protowire.Fixed64Type:
+						v, n := protowire.ConsumeFixed64(b)
+						sample.Value = math.Float64frombits(v)
+						return n, nil
+					case num == 2 && typ == protowire.VarintType:
+						v, n := protowire.ConsumeVarint(b)
+						sample.Timestamp = int64(v)
+						return n, nil
+					}
+					return 0, nil
+				})
+				ts.Samples = append(ts.Samples, sample)
+				return n, err
+			}
+			return n, nil
+		})
+		series = append(series, ts)
+		return n, err
+	})
+	return series, err
+}
+
+// Receiver is a stub remote write endpoint logging the series it is sent,
+// to try the writer without a metrics store
+func Receiver() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		series, err := Decode(body)
+		if err != nil {
+			slog.Error("Failed to decode remote write request", slog.Any("error", err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, ts := range series {
+			slog.Info("Received series", slog.Any("labels", ts.Labels), slog.Any("samples", ts.Samples))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	return http.HandlerFunc(fn)
+}
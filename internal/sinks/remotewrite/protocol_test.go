@@ -0,0 +1,34 @@
+package remotewrite
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	series := []TimeSeries{
+		{
+			Labels:  []Label{{"__name__", "hosts_load"}, {"host", "a"}},
+			Samples: []Sample{{1.5, 1700000000000}, {-2, 1700000060000}},
+		},
+		{
+			Labels:  []Label{{"__name__", "hosts_count"}, {"empty", ""}},
+			Samples: []Sample{{math.MaxFloat64, 0}},
+		},
+	}
+
+	decoded, err := Decode(Encode(series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, series) {
+		t.Fatalf("got %+v, want %+v", decoded, series)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	if _, err := Decode([]byte("not snappy")); err == nil {
+		t.Fatal("want an error")
+	}
+}
@@ -0,0 +1,204 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"d34d.one/grognon/internal/database"
+	"d34d.one/grognon/internal/sinks"
+)
+
+const queueExt = ".snappy"
+
+var headers = map[string]string{
+	"Content-Encoding":                  "snappy",
+	"Content-Type":                      "application/x-protobuf",
+	"X-Prometheus-Remote-Write-Version": "0.1.0",
+}
+
+type Config struct {
+	URL string
+	// Requests waiting to be sent are kept in this folder across restarts
+	QueueDir string
+	// Samples per request
+	BatchSize int
+	// Pending samples are queued at least this often
+	FlushInterval time.Duration
+	// Oldest requests are dropped past this many queued ones
+	MaxQueued int
+	Timeout   time.Duration
+}
+
+// Writer pushes the samples of every cron run to a remote write endpoint. Samples are
+// batched in memory, then queued on disk until the endpoint accepts them, unlike
+// the other sinks nothing is lost across restarts.
+type Writer struct {
+	cfg    Config
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []TimeSeries
+	samples int
+	seq     int64
+	wake    chan struct{}
+}
+
+func New(cfg Config) (*Writer, error) {
+	if err := os.MkdirAll(cfg.QueueDir, 0755); err != nil {
+		return nil, fmt.Errorf("remote write: failed to create queue folder: %w", err)
+	}
+	return &Writer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+func (w *Writer) Name() string { return "remote_write" }
+
+func (w *Writer) Observe(batch database.SampleBatch) {
+	series := seriesOf(batch)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, series...)
+	w.samples += len(series)
+	if w.samples >= w.cfg.BatchSize {
+		w.flushLocked()
+	}
+}
+
+// flushLocked writes the pending samples to the queue in requests of BatchSize samples
+func (w *Writer) flushLocked() {
+	for len(w.pending) > 0 {
+		n := min(len(w.pending), w.cfg.BatchSize)
+		if err := w.enqueue(Encode(w.pending[:n])); err != nil {
+			slog.Error("Error queuing remote write request", slog.Any("error", err))
+		}
+		w.pending = w.pending[n:]
+	}
+	w.pending = nil
+	w.samples = 0
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Writer) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushLocked()
+}
+
+// enqueue writes a request atomically, file names keep the queue in order
+func (w *Writer) enqueue(req []byte) error {
+	w.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), w.seq%1_000_000, queueExt)
+	tmp := filepath.Join(w.cfg.QueueDir, name+".tmp")
+	if err := os.WriteFile(tmp, req, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(w.cfg.QueueDir, name))
+}
+
+func (w *Writer) queued() ([]string, error) {
+	entries, err := os.ReadDir(w.cfg.QueueDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), queueExt) {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+
+	if w.cfg.MaxQueued > 0 && len(names) > w.cfg.MaxQueued {
+		dropped := names[:len(names)-w.cfg.MaxQueued]
+		slog.Warn("Remote write queue full, dropping oldest requests", slog.Int("count", len(dropped)))
+		for _, name := range dropped {
+			if err := os.Remove(filepath.Join(w.cfg.QueueDir, name)); err != nil {
+				slog.Error("Error dropping remote write request", slog.String("file", name), slog.Any("error", err))
+			}
+		}
+		names = names[len(dropped):]
+	}
+	return names, nil
+}
+
+// drain sends the queued requests in order, stopping at the first one to retry
+func (w *Writer) drain(ctx context.Context) error {
+	names, err := w.queued()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		path := filepath.Join(w.cfg.QueueDir, name)
+		req, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		err = sinks.Post(ctx, w.client, w.cfg.URL, headers, req)
+		var retryable sinks.RetryableError
+		if errors.As(err, &retryable) {
+			return err
+		}
+		// Requests the endpoint refuses would be refused forever
+		if err != nil {
+			slog.Error("Remote write request rejected, dropping it", slog.String("file", name), slog.Any("error", err))
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run sends the queue until the context is done, backing off exponentially on failures
+func (w *Writer) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var backoff time.Duration
+	var nextAttempt time.Time
+	for {
+		if !time.Now().Before(nextAttempt) {
+			if err := w.drain(ctx); err != nil {
+				backoff = sinks.Backoff(backoff)
+				nextAttempt = time.Now().Add(backoff)
+				slog.Error("Error sending remote write requests", slog.Duration("retry_in", backoff), slog.Any("error", err))
+			} else {
+				backoff = 0
+			}
+		}
+
+		var retry <-chan time.Time
+		if backoff > 0 {
+			retry = time.After(time.Until(nextAttempt))
+		}
+		select {
+		case <-ctx.Done():
+			// Pending samples are kept for the next start
+			w.flush()
+			return
+		case <-ticker.C:
+			w.flush()
+		case <-w.wake:
+		case <-retry:
+		}
+	}
+}
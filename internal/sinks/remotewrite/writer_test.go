@@ -0,0 +1,70 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"d34d.one/grognon/internal/database"
+)
+
+func TestWriterSendsToReceiver(t *testing.T) {
+	var received []TimeSeries
+	receiver := Receiver()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("got Content-Encoding %q", r.Header.Get("Content-Encoding"))
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		// The receiver only logs what it decodes, keep a copy to compare
+		if series, err := Decode(body); err == nil {
+			received = append(received, series...)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		receiver.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	writer, err := New(Config{URL: server.URL, QueueDir: t.TempDir(), BatchSize: 2, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.UnixMilli(1700000000000)
+	writer.Observe(database.SampleBatch{
+		Cron:    database.Cron{Slug: "hosts"},
+		Time:    now,
+		Labels:  []string{"Host"},
+		Metrics: []database.CronOutput{{Name: "Load", Type: "INTEGER"}},
+		Rows: []database.Object{
+			{"host": "a", "load": int64(1)},
+			{"host": "b", "load": nil},
+			{"host": "c", "load": int64(3)},
+		},
+	})
+	if err := writer.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TimeSeries{
+		{Labels: []Label{{"Host", "a"}, {"__name__", "hosts_Load"}}, Samples: []Sample{{1, now.UnixMilli()}}},
+		{Labels: []Label{{"Host", "c"}, {"__name__", "hosts_Load"}}, Samples: []Sample{{3, now.UnixMilli()}}},
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Fatalf("got %+v, want %+v", received, want)
+	}
+	names, err := writer.queued()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("%d requests left in the queue", len(names))
+	}
+}
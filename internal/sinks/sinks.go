@@ -0,0 +1,64 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"d34d.one/grognon/internal/database"
+)
+
+// Sink pushes the samples of every cron run to an external store
+type Sink interface {
+	Name() string
+	// Observe is given every run once saved, it must not block the cron executor
+	Observe(batch database.SampleBatch)
+	// Run delivers the observed samples until the context is done
+	Run(ctx context.Context)
+}
+
+// Setup feeds the sinks with the runs of the cron executor
+func Setup(ctx context.Context, db *database.Database, sinks []Sink) {
+	for _, sink := range sinks {
+		slog.Info("Starting sink", slog.String("sink", sink.Name()))
+		db.AddObserver(sink.Observe)
+		go sink.Run(ctx)
+	}
+}
+
+// RetryableError marks failures worth sending the samples again
+type RetryableError struct{ error }
+
+func (e RetryableError) Unwrap() error { return e.error }
+
+// Post sends a payload, network errors and 429 or 5xx responses are retryable
+func Post(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return RetryableError{err}
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			slog.Error("Error closing sink response", slog.Any("error", err))
+		}
+	}()
+
+	switch {
+	case res.StatusCode/100 == 2:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode/100 == 5:
+		return RetryableError{fmt.Errorf("%s: %s", url, res.Status)}
+	default:
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+}
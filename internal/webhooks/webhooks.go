@@ -0,0 +1,107 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"d34d.one/grognon/internal/database"
+)
+
+const (
+	// Deliveries attempted at each tick
+	batchSize = 50
+	timeout   = 10 * time.Second
+)
+
+var client = &http.Client{Timeout: timeout}
+
+// Sign computes the X-Grognon-Signature header of a payload, receivers compare it
+// with the HMAC-SHA256 of the raw body using the webhook secret
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Observe queues a delivery of every event to the webhooks subscribed to it
+func Observe(db *database.Database) database.EventObserver {
+	return func(event database.Event) {
+		if err := database.QueueWebhookDeliveries(db, event); err != nil {
+			slog.Error("Error queuing webhook deliveries", slog.String("event", event.Type), slog.Any("error", err))
+		}
+	}
+}
+
+// DeliverDue posts the pending deliveries whose next attempt has come
+func DeliverDue(ctx context.Context, db *database.Database) error {
+	deliveries, err := database.GetDueDeliveries(db, batchSize)
+	if err != nil {
+		return err
+	}
+
+	webhooks := map[int64]*database.Webhook{}
+	for _, delivery := range deliveries {
+		webhook, ok := webhooks[delivery.WebhookId]
+		if !ok {
+			if webhook, err = database.GetWebhook(db, delivery.WebhookId); err != nil {
+				slog.Error("Error getting webhook", slog.Int64("id", delivery.WebhookId), slog.Any("error", err))
+			}
+			webhooks[delivery.WebhookId] = webhook
+		}
+		if webhook == nil {
+			continue
+		}
+
+		status, err := deliver(ctx, *webhook, delivery)
+		if err != nil {
+			slog.Warn("Webhook delivery failed", slog.Int64("id", delivery.DeliveryId), slog.String("url", webhook.Url), slog.Any("error", err))
+		}
+		if err := database.SaveDeliveryAttempt(db, delivery, status, err); err != nil {
+			slog.Error("Error saving webhook delivery", slog.Int64("id", delivery.DeliveryId), slog.Any("error", err))
+		}
+	}
+	return nil
+}
+
+// deliver returns the response status alongside the error, when there was a response
+func deliver(ctx context.Context, webhook database.Webhook, delivery database.WebhookDelivery) (int, error) {
+	payload := []byte(delivery.Payload)
+	headers := map[string]string{
+		"Content-Type":        "application/json",
+		"User-Agent":          "grognon-webhooks",
+		"X-Grognon-Event":     delivery.Event,
+		"X-Grognon-Delivery":  strconv.FormatInt(delivery.DeliveryId, 10),
+		"X-Grognon-Signature": Sign(webhook.Secret, payload),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			slog.Error("Error closing webhook response", slog.Any("error", err))
+		}
+	}()
+
+	if res.StatusCode/100 != 2 {
+		return res.StatusCode, fmt.Errorf("%s: %s", webhook.Url, res.Status)
+	}
+	return res.StatusCode, nil
+}
@@ -2,19 +2,37 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"d34d.one/grognon/internal/backend"
 	"d34d.one/grognon/internal/background"
 	"d34d.one/grognon/internal/database"
+	"d34d.one/grognon/internal/notify"
+	"d34d.one/grognon/internal/sinks"
+	"d34d.one/grognon/internal/sinks/influx"
+	"d34d.one/grognon/internal/sinks/otlp"
+	"d34d.one/grognon/internal/sinks/remotewrite"
 	"github.com/urfave/cli/v3"
 )
 
 type Config struct {
-	Data    string
-	SsrHost string
-	DBUrl   string
+	Data     string
+	SsrHost  string
+	DBUrl    string
+	Settings database.Settings
+
+	RemoteWrite remotewrite.Config
+	OTLP        otlp.Config
+	Influx      influx.Config
+	Notify      notify.Config
 }
 
 func action(ctx context.Context, cfg Config) error {
@@ -25,7 +43,7 @@ func action(ctx context.Context, cfg Config) error {
 		}
 	}
 
-	db, err := database.Setup(ctx, cfg.DBUrl)
+	db, err := database.Setup(ctx, cfg.DBUrl, cfg.Settings)
 	if err != nil {
 		return cli.Exit(err, 1)
 	}
@@ -34,10 +52,37 @@ func action(ctx context.Context, cfg Config) error {
 		return cli.Exit(err, 1)
 	}
 
+	var enabledSinks []sinks.Sink
+	if cfg.RemoteWrite.URL != "" {
+		cfg.RemoteWrite.QueueDir = filepath.Join(cfg.Data, "remote_write")
+		writer, err := remotewrite.New(cfg.RemoteWrite)
+		if err != nil {
+			return cli.Exit(err, 1)
+		}
+		enabledSinks = append(enabledSinks, writer)
+	}
+	if cfg.OTLP.Endpoint != "" {
+		enabledSinks = append(enabledSinks, otlp.New(cfg.OTLP))
+	}
+	if cfg.Influx.URL != "" {
+		enabledSinks = append(enabledSinks, influx.New(cfg.Influx))
+	}
+	sinks.Setup(ctx, db, enabledSinks)
+
+	notifier := notify.New(db, cfg.Notify)
+	db.AddEventObserver(notifier.Observe)
+	go notifier.Run(ctx)
+	background.SetupNotificationLog(ctx, db)
+
+	background.SetupWebhooks(ctx, db)
 	background.SetupReflection(ctx, db, cons)
 	background.SetupCronJobs(ctx, db, cons)
+	background.SetupRetention(ctx, db)
+	background.SetupRunLog(ctx, db)
+	background.SetupRollups(ctx, db)
+	background.SetupPartitions(ctx, db)
 
-	if err := backend.Setup(db, cons, cfg.SsrHost); err != nil {
+	if err := backend.Setup(db, cons, notifier, cfg.SsrHost); err != nil {
 		return cli.Exit(err, 1)
 	}
 
@@ -45,6 +90,158 @@ func action(ctx context.Context, cfg Config) error {
 	return nil
 }
 
+// importAction loads a CSV file into a cron, like the upload endpoint does
+func importAction(ctx context.Context, cmd *cli.Command) error {
+	if cmd.String("db") == "" {
+		return cli.Exit("the --db flag is required", 1)
+	}
+	db, err := database.Setup(ctx, cmd.String("db"), database.Settings{})
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	cron, err := database.GetCron(db, cmd.Int64("cron"))
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	outputs, err := database.GetCronOutputs(db, cron.CronId)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	opts := database.CronImport{
+		TimestampColumn: cmd.String("timestamp-column"),
+		TimeFormats:     cmd.StringSlice("time-format"),
+		Mapping:         make(map[string]string),
+		DryRun:          cmd.Bool("dry-run"),
+	}
+	for _, mapping := range cmd.StringSlice("map") {
+		col, output, ok := strings.Cut(mapping, "=")
+		if !ok {
+			return cli.Exit(fmt.Sprintf("invalid mapping %s, expected column=output", mapping), 1)
+		}
+		opts.Mapping[col] = output
+	}
+
+	var r io.Reader = os.Stdin
+	if path := cmd.String("file"); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return cli.Exit(err, 1)
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				slog.Error("Error closing import file", slog.Any("error", err))
+			}
+		}()
+		r = file
+	}
+
+	report, err := database.ImportCronCSV(ctx, db, *cron, outputs, r, opts)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	verb := "Imported"
+	if report.DryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("%s %d rows, rejected %d\n", verb, report.Imported, report.Rejected)
+	for _, rejection := range report.Rejections {
+		fmt.Printf("line %d: %s\n", rejection.Line, rejection.Error)
+	}
+	if int64(len(report.Rejections)) < report.Rejected {
+		fmt.Printf("and %d more\n", report.Rejected-int64(len(report.Rejections)))
+	}
+	return nil
+}
+
+func tokenDB(ctx context.Context, cmd *cli.Command) (*database.Database, error) {
+	if cmd.String("db") == "" {
+		return nil, cli.Exit("the --db flag is required", 1)
+	}
+	db, err := database.Setup(ctx, cmd.String("db"), database.Settings{})
+	if err != nil {
+		return nil, cli.Exit(err, 1)
+	}
+	return db, nil
+}
+
+// tokenCreateAction prints the secret of the new token, the only time it is shown
+func tokenCreateAction(ctx context.Context, cmd *cli.Command) error {
+	db, err := tokenDB(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	token, secret, err := database.AddAPIToken(db, database.APITokenCreate{
+		Name:          cmd.String("name"),
+		Kind:          cmd.String("kind"),
+		Scopes:        cmd.StringSlice("scope"),
+		ExpiresInDays: cmd.Int64("expires-in-days"),
+	})
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Created token %d, it won't be shown again\n", token.TokenId)
+	fmt.Println(secret)
+	return nil
+}
+
+func tokenListAction(ctx context.Context, cmd *cli.Command) error {
+	db, err := tokenDB(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := database.GetAPITokens(db)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	// The tabwriter buffers the rows, write errors surface on flush
+	_, _ = fmt.Fprintln(w, "ID\tNAME\tKIND\tPREFIX\tSCOPES\tEXPIRES\tLAST USED\tREVOKED")
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+	for _, token := range tokens {
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			token.TokenId, token.Name, token.Kind, token.Prefix, strings.Join(token.Scopes, ","),
+			formatTime(token.ExpiresAt), formatTime(token.LastUsedAt), formatTime(token.RevokedAt))
+	}
+	if err := w.Flush(); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return nil
+}
+
+func tokenRevokeAction(ctx context.Context, cmd *cli.Command) error {
+	db, err := tokenDB(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := database.RevokeAPIToken(db, cmd.Int64("id")); err != nil {
+		return cli.Exit(err, 1)
+	}
+	fmt.Printf("Revoked token %d\n", cmd.Int64("id"))
+	return nil
+}
+
+// receiverAction serves a stub remote write endpoint, to check what the writer sends
+func receiverAction(ctx context.Context, cmd *cli.Command) error {
+	slog.Info("Remote write receiver listening", slog.String("address", cmd.String("listen")))
+	if err := http.ListenAndServe(cmd.String("listen"), remotewrite.Receiver()); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return nil
+}
+
 func main() {
 	flags := []cli.Flag{
 		&cli.StringFlag{
@@ -58,20 +255,241 @@ func main() {
 			Usage: "Hostname for SSR",
 		},
 		&cli.StringFlag{
-			Name:     "db",
-			Required: true,
-			Usage:    "Database connection string",
+			Name:  "db",
+			Usage: "Database connection string, required by the server, import and token",
+		},
+		&cli.Int64Flag{
+			Name:  "max-rows",
+			Value: 100_000,
+			Usage: "Maximum number of rows saved per cron run, 0 to disable",
+		},
+		&cli.Int64Flag{
+			Name:  "max-bytes",
+			Value: 64 << 20,
+			Usage: "Maximum size in bytes of the results of a cron run, 0 to disable",
+		},
+		&cli.Int64Flag{
+			Name:  "retention-days",
+			Value: 0,
+			Usage: "Days of data kept for crons without their own retention, 0 to keep everything",
+		},
+		&cli.StringFlag{
+			Name:  "remote-write-url",
+			Usage: "Prometheus remote write endpoint receiving the samples of every run, disabled when empty",
+		},
+		&cli.IntFlag{
+			Name:  "remote-write-batch",
+			Value: 500,
+			Usage: "Samples per remote write request",
+		},
+		&cli.IntFlag{
+			Name:  "remote-write-max-queued",
+			Value: 10_000,
+			Usage: "Remote write requests kept on disk while the endpoint is down",
+		},
+		&cli.StringFlag{
+			Name:  "otlp-endpoint",
+			Usage: "OTLP/HTTP receiver getting the samples of every run as gauges, like http://localhost:4318, disabled when empty",
+		},
+		&cli.StringSliceFlag{
+			Name:  "otlp-header",
+			Usage: "Header sent to the OTLP receiver as key=value, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "influx-url",
+			Usage: "InfluxDB server getting the samples of every run in the line protocol, disabled when empty",
+		},
+		&cli.StringFlag{
+			Name:  "influx-org",
+			Usage: "InfluxDB organization",
+		},
+		&cli.StringFlag{
+			Name:  "influx-bucket",
+			Usage: "InfluxDB bucket",
+		},
+		&cli.StringFlag{
+			Name:    "influx-token",
+			Sources: cli.EnvVars("GROGNON_INFLUX_TOKEN"),
+			Usage:   "InfluxDB API token",
+		},
+		&cli.StringFlag{
+			Name:  "smtp-host",
+			Usage: "SMTP server sending the email notifications, disabled when empty",
+		},
+		&cli.IntFlag{
+			Name:  "smtp-port",
+			Value: 587,
+			Usage: "SMTP server port",
+		},
+		&cli.StringFlag{
+			Name:  "smtp-username",
+			Usage: "SMTP username, no authentication when empty",
+		},
+		&cli.StringFlag{
+			Name:    "smtp-password",
+			Sources: cli.EnvVars("GROGNON_SMTP_PASSWORD"),
+			Usage:   "SMTP password",
+		},
+		&cli.StringFlag{
+			Name:  "smtp-from",
+			Value: "grognon@localhost",
+			Usage: "Sender of the email notifications",
+		},
+		&cli.BoolFlag{
+			Name:  "smtp-starttls",
+			Value: true,
+			Usage: "Require STARTTLS, disable it for a local SMTP catcher with --smtp-starttls=false",
+		},
+	}
+	importCmd := &cli.Command{
+		Name:  "import",
+		Usage: "Import historical samples from a CSV file into a cron",
+		Flags: []cli.Flag{
+			&cli.Int64Flag{
+				Name:     "cron",
+				Required: true,
+				Usage:    "Id of the cron receiving the samples",
+			},
+			&cli.StringFlag{
+				Name:     "file",
+				Required: true,
+				Usage:    "CSV file to import, - for stdin",
+			},
+			&cli.StringFlag{
+				Name:  "timestamp-column",
+				Value: "timestamp",
+				Usage: "CSV column holding the time of the samples",
+			},
+			&cli.StringSliceFlag{
+				Name:  "time-format",
+				Usage: "Go time layout of the timestamps, can be repeated",
+			},
+			&cli.StringSliceFlag{
+				Name:  "map",
+				Usage: "Map a CSV column to an output as column=output, can be repeated",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report the lines that would be rejected",
+			},
+		},
+		Action: importAction,
+	}
+	receiverCmd := &cli.Command{
+		Name:  "remote-write-receiver",
+		Usage: "Log the series sent by the remote write sink, for development",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen",
+				Value: "127.0.0.1:9201",
+				Usage: "Address to listen on",
+			},
+		},
+		Action: receiverAction,
+	}
+	tokenCmd := &cli.Command{
+		Name:  "token",
+		Usage: "Manage the API tokens",
+		Commands: []*cli.Command{
+			{
+				Name:  "create",
+				Usage: "Create a token and print it",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "name",
+						Required: true,
+						Usage:    "Name of the token",
+					},
+					&cli.StringFlag{
+						Name:  "kind",
+						Value: database.TokenService,
+						Usage: "personal or service",
+					},
+					&cli.StringSliceFlag{
+						Name:     "scope",
+						Required: true,
+						Usage:    "Scope of the token: read:data, manage:crons or manage:connections, can be repeated",
+					},
+					&cli.Int64Flag{
+						Name:  "expires-in-days",
+						Value: 90,
+						Usage: "Days before the token expires, 0 for never",
+					},
+				},
+				Action: tokenCreateAction,
+			},
+			{
+				Name:   "list",
+				Usage:  "List the tokens",
+				Action: tokenListAction,
+			},
+			{
+				Name:  "revoke",
+				Usage: "Revoke a token",
+				Flags: []cli.Flag{
+					&cli.Int64Flag{
+						Name:     "id",
+						Required: true,
+						Usage:    "Id of the token",
+					},
+				},
+				Action: tokenRevokeAction,
+			},
 		},
 	}
 	cmd := cli.Command{
-		Name:  "grognon",
-		Usage: "Scavage for statistics",
-		Flags: flags,
+		Name:     "grognon",
+		Usage:    "Scavage for statistics",
+		Flags:    flags,
+		Commands: []*cli.Command{importCmd, receiverCmd, tokenCmd},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
+			// Not a required flag, the development subcommands don't need it
+			if cmd.String("db") == "" {
+				return cli.Exit("the --db flag is required", 1)
+			}
+			otlpHeaders, err := otlp.ParseHeaders(cmd.StringSlice("otlp-header"))
+			if err != nil {
+				return cli.Exit(err, 1)
+			}
 			config := Config{
 				Data:    cmd.String("data"),
 				SsrHost: cmd.String("ssr"),
 				DBUrl:   cmd.String("db"),
+				Settings: database.Settings{
+					MaxRows:  cmd.Int64("max-rows"),
+					MaxBytes: cmd.Int64("max-bytes"),
+
+					RetentionDays: cmd.Int64("retention-days"),
+				},
+				RemoteWrite: remotewrite.Config{
+					URL:           cmd.String("remote-write-url"),
+					BatchSize:     cmd.Int("remote-write-batch"),
+					FlushInterval: 10 * time.Second,
+					MaxQueued:     cmd.Int("remote-write-max-queued"),
+					Timeout:       30 * time.Second,
+				},
+				OTLP: otlp.Config{
+					Endpoint: cmd.String("otlp-endpoint"),
+					Headers:  otlpHeaders,
+					Timeout:  30 * time.Second,
+				},
+				Influx: influx.Config{
+					URL:     cmd.String("influx-url"),
+					Org:     cmd.String("influx-org"),
+					Bucket:  cmd.String("influx-bucket"),
+					Token:   cmd.String("influx-token"),
+					Timeout: 30 * time.Second,
+				},
+				Notify: notify.Config{
+					SMTP: notify.SMTPConfig{
+						Host:     cmd.String("smtp-host"),
+						Port:     cmd.Int("smtp-port"),
+						Username: cmd.String("smtp-username"),
+						Password: cmd.String("smtp-password"),
+						From:     cmd.String("smtp-from"),
+						StartTLS: cmd.Bool("smtp-starttls"),
+					},
+				},
 			}
 			return action(ctx, config)
 		},